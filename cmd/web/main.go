@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"log"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	_ "github.com/qsheker/ToDo-app/docs"
 	"github.com/qsheker/ToDo-app/internal/handlers"
+	"github.com/qsheker/ToDo-app/internal/mail"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/notify"
 	"github.com/qsheker/ToDo-app/internal/repository"
 	"github.com/qsheker/ToDo-app/internal/routes"
 	"github.com/qsheker/ToDo-app/internal/service"
+	"github.com/spf13/viper"
 )
 
 // @title ToDo App API
@@ -15,22 +23,151 @@ import (
 
 // @host localhost:8081
 // @BasePath /
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name Authorization
 func main() {
 	r := gin.Default()
 	injector := repository.Injector()
+	// Client IPs drive sign-in lockouts, so only trust forwarding headers from known proxies.
+	if err := r.SetTrustedProxies(viper.GetStringSlice("app.trusted_proxies")); err != nil {
+		log.Fatal("Invalid trusted proxies: ", err)
+	}
 
-	todoRepo := repository.NewTodoRepository(injector)
+	todoRepo := repository.NewTodoRepository(injector, viper.GetString("database.search_language"))
+	reminderRepo := repository.NewReminderRepository(injector)
+	tagRepo := repository.NewTagRepository(injector)
 	userRepo := repository.NewUserRepository(injector)
+	refreshRepo := repository.NewRefreshTokenRepository(injector)
+	patRepo := repository.NewPersonalAccessTokenRepository(injector)
+	resetRepo := repository.NewPasswordResetRepository(injector)
+	recoveryRepo := repository.NewRecoveryCodeRepository(injector)
+	externalIdentityRepo := repository.NewExternalIdentityRepository(injector)
+	sessionRepo := repository.NewSessionRepository(injector)
+	passwordHistoryRepo := repository.NewPasswordHistoryRepository(injector)
+
+	var revocations repository.RevocationStore
+	if viper.GetString("auth.revocation_store") == "memory" {
+		revocations = repository.NewMemoryRevocationStore()
+	} else {
+		revocations = repository.NewRevocationStore(injector)
+	}
+	repository.StartPruner(context.Background(), "revoked tokens", revocations, 10*time.Minute)
+
+	var attempts repository.AttemptStore
+	if viper.GetString("auth.attempt_store") == "memory" {
+		attempts = repository.NewMemoryAttemptStore()
+	} else {
+		attempts = repository.NewAttemptStore(injector)
+	}
+	repository.StartPruner(context.Background(), "sign-in attempts", attempts, 10*time.Minute)
+
+	var keySetConfig service.KeySetConfig
+	if err := viper.UnmarshalKey("jwt", &keySetConfig); err != nil {
+		log.Fatal("Invalid jwt config: ", err)
+	}
+	keySet, err := service.NewKeySet(keySetConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var mailer mail.Mailer
+	if viper.GetString("mail.driver") == "smtp" {
+		var smtpConfig mail.SMTPConfig
+		if err := viper.UnmarshalKey("mail.smtp", &smtpConfig); err != nil {
+			log.Fatal("Invalid smtp config: ", err)
+		}
+		mailer = mail.NewSMTPMailer(smtpConfig)
+	} else {
+		mailer = mail.NewOutboxMailer(viper.GetString("mail.from"), viper.GetString("mail.outbox_dir"))
+	}
 
-	todoService := service.NewTodoService(todoRepo)
-	userService := service.NewUserService(userRepo)
-	jwtService := service.NewJwtService(userRepo)
+	todoService := service.NewTodoService(todoRepo, reminderRepo)
+	service.StartRankRebalancer(context.Background(), todoService, 10*time.Minute)
+	var policyConfig service.PasswordPolicyConfig
+	if err := viper.UnmarshalKey("auth.password_policy", &policyConfig); err != nil {
+		log.Fatal("Invalid password policy config: ", err)
+	}
+	var hashingConfig service.Argon2Params
+	if err := viper.UnmarshalKey("auth.password_hashing", &hashingConfig); err != nil {
+		log.Fatal("Invalid password hashing config: ", err)
+	}
+	usernameReservation := viper.GetDuration("account.username_reservation_period")
+	if usernameReservation == 0 {
+		usernameReservation = 30 * 24 * time.Hour
+	}
+	jwtService := service.NewJwtService(keySet, userRepo, refreshRepo, revocations, sessionRepo)
+	userService := service.NewUserService(userRepo, passwordHistoryRepo, patRepo, service.NewPasswordPolicy(policyConfig), service.NewPasswordHasher(hashingConfig), jwtService, usernameReservation)
+	if viper.IsSet("bootstrap.admin.username") {
+		admin := &models.CreateUserRequest{
+			Name:     viper.GetString("bootstrap.admin.name"),
+			Username: viper.GetString("bootstrap.admin.username"),
+			Password: viper.GetString("bootstrap.admin.password"),
+		}
+		if err := userService.BootstrapAdmin(admin); err != nil {
+			log.Fatal("Admin bootstrap failed: ", err)
+		}
+	}
 
-	authHandler := handlers.NewAuthHandler(userService, jwtService)
+	patService := service.NewPersonalAccessTokenService(patRepo, userRepo)
+	resetService := service.NewPasswordResetService(resetRepo, userRepo, userService, attempts, mailer, viper.GetString("auth.password_reset_url"))
+	verificationService := service.NewEmailVerificationService(keySet, userRepo, mailer, viper.GetString("auth.email_verification_url"))
+	twoFactorService := service.NewTwoFactorService(keySet, userRepo, recoveryRepo, attempts, revocations)
+
+	var throttleConfig service.LoginThrottleConfig
+	if err := viper.UnmarshalKey("auth.lockout", &throttleConfig); err != nil {
+		log.Fatal("Invalid lockout config: ", err)
+	}
+	throttle := service.NewLoginThrottle(throttleConfig, attempts, userRepo)
+
+	gracePeriod := viper.GetDuration("account.deletion_grace_period")
+	if gracePeriod == 0 {
+		gracePeriod = 30 * 24 * time.Hour
+	}
+	accountService := service.NewAccountService(userRepo, todoRepo, reminderRepo, tagRepo, sessionRepo, patRepo, externalIdentityRepo, jwtService, gracePeriod)
+	repository.StartPruner(context.Background(), "deleted accounts", accountService, time.Hour)
+
+	var notifier notify.Notifier
+	if viper.GetString("reminders.notifier") == "mail" {
+		notifier = notify.NewMailNotifier(mailer)
+	} else {
+		notifier = notify.NewLogNotifier()
+	}
+	deliveries := repository.NewDeliveryStore(injector)
+	repository.StartPruner(context.Background(), "reminder deliveries", deliveries, time.Hour)
+	notifier = notify.NewIdempotentNotifier(notifier, deliveries)
+	reminderService := service.NewReminderService(reminderRepo, todoRepo, userRepo, notifier)
+	reminderInterval := viper.GetDuration("reminders.interval")
+	if reminderInterval == 0 {
+		reminderInterval = 30 * time.Second
+	}
+	service.StartReminderScheduler(context.Background(), reminderService, reminderInterval)
+
+	authHandler := handlers.NewAuthHandler(userService, jwtService, patService, resetService, verificationService, twoFactorService, throttle)
 	todoHandler := handlers.NewTodoHandler(todoService)
-	userHandler := handlers.NewUserHandler(userService)
+	userHandler := handlers.NewUserHandler(userService, throttle, accountService)
+	wellKnownHandler := handlers.NewWellKnownHandler(jwtService)
+	tokenHandler := handlers.NewPersonalAccessTokenHandler(patService)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService)
+	sessionHandler := handlers.NewSessionHandler(jwtService)
+	reminderHandler := handlers.NewReminderHandler(reminderService)
+	tagHandler := handlers.NewTagHandler(service.NewTagService(tagRepo, todoRepo))
+
+	var oidcHandler *handlers.OIDCHandler
+	var oidcConfig service.OIDCConfig
+	if err := viper.UnmarshalKey("oidc", &oidcConfig); err != nil {
+		log.Fatal("Invalid oidc config: ", err)
+	}
+	if oidcConfig.Enabled {
+		oidcService, err := service.NewOIDCService(context.Background(), oidcConfig, keySet, userRepo, externalIdentityRepo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		oidcHandler = handlers.NewOIDCHandler(oidcService, jwtService)
+	}
 
-	routes.RegisterRoutes(r, todoHandler, userHandler, authHandler)
+	routes.RegisterRoutes(r, todoHandler, userHandler, authHandler, wellKnownHandler, tokenHandler, twoFactorHandler, oidcHandler, sessionHandler, reminderHandler, tagHandler)
 
 	r.Run("localhost:8081")
 }
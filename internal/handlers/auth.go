@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/qsheker/ToDo-app/internal/models"
@@ -9,22 +13,39 @@ import (
 )
 
 type AuthHandler struct {
-	userService service.UserService
-	jwtService  service.JwtService
+	userService         service.UserService
+	jwtService          service.JwtService
+	patService          service.PersonalAccessTokenService
+	resetService        service.PasswordResetService
+	verificationService service.EmailVerificationService
+	twoFactorService    service.TwoFactorService
+	throttle            service.LoginThrottle
 }
 
-func NewAuthHandler(userService service.UserService, jwtService service.JwtService) *AuthHandler {
-	return &AuthHandler{userService: userService, jwtService: jwtService}
+func NewAuthHandler(userService service.UserService, jwtService service.JwtService, patService service.PersonalAccessTokenService, resetService service.PasswordResetService, verificationService service.EmailVerificationService, twoFactorService service.TwoFactorService, throttle service.LoginThrottle) *AuthHandler {
+	return &AuthHandler{
+		userService:         userService,
+		jwtService:          jwtService,
+		patService:          patService,
+		resetService:        resetService,
+		verificationService: verificationService,
+		twoFactorService:    twoFactorService,
+		throttle:            throttle,
+	}
 }
 
 // @Summary      Sign in
-// @Description  Authenticate user and return a JWT token
+// @Description  Authenticate user and return a JWT access token and a refresh token.
+// @Description  Accounts with 2FA get a challenge token to complete at /auth/sign-in/2fa instead.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        input  body      models.LoginRequest  true  "User credentials"
-// @Success      200    {object}  map[string]string     "token"
+// @Success      200    {object}  models.TokenResponse
+// @Success      202    {object}  models.TwoFactorChallengeResponse
 // @Failure      400    {object}  map[string]string     "Invalid request"
+// @Failure      401    {object}  map[string]string     "Invalid credentials"
+// @Failure      429    {object}  map[string]string     "Too many failed attempts"
 // @Failure      502    {object}  map[string]string     "Failed to generate token"
 // @Router       /auth/sign-in [post]
 func (h *AuthHandler) SignIn(c *gin.Context) {
@@ -35,27 +56,171 @@ func (h *AuthHandler) SignIn(c *gin.Context) {
 		})
 		return
 	}
-	token, err := h.jwtService.GenerateToken(user.Username, user.Password)
+	ip := c.ClientIP()
+	if err := h.throttle.Check(user.Username, ip); err != nil {
+		respondThrottled(c, err)
+		return
+	}
+	authUser, err := h.userService.Authenticate(user.Username, user.Password)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			if err := h.throttle.Failure(user.Username, ip); err != nil {
+				log.Println("Error recording failed sign-in: ", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusBadGateway, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"token": token,
-	})
+	if authUser.TwoFactorEnabled {
+		challenge, err := h.twoFactorService.Challenge(authUser)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusAccepted, models.TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
+		return
+	}
+	if err := h.throttle.Success(authUser.Username); err != nil {
+		log.Println("Error resetting sign-in attempts: ", err)
+	}
+	tokens, err := h.jwtService.IssueTokens(authUser, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// @Summary      Complete 2FA sign in
+// @Description  Exchange a sign-in challenge and a TOTP or recovery code for tokens
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.TwoFactorSignInRequest  true  "Challenge token and code"
+// @Success      200    {object}  models.TokenResponse
+// @Failure      400    {object}  map[string]string
+// @Failure      401    {object}  map[string]string
+// @Failure      429    {object}  map[string]string
+// @Failure      502    {object}  map[string]string
+// @Router       /auth/sign-in/2fa [post]
+func (h *AuthHandler) SignInTwoFactor(c *gin.Context) {
+	var input models.TwoFactorSignInRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Wrong codes count against the challenged account as well as the
+	// client IP, so rotating addresses does not buy more guesses.
+	ip := c.ClientIP()
+	var username string
+	if challenged, err := h.twoFactorService.ChallengedUser(input.ChallengeToken); err == nil {
+		username = challenged.Username
+	}
+	if err := h.throttle.Check(username, ip); err != nil {
+		respondThrottled(c, err)
+		return
+	}
+	user, err := h.twoFactorService.CompleteChallenge(input.ChallengeToken, input.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidChallenge) || errors.Is(err, service.ErrInvalidTwoFactorCode) {
+			if err := h.throttle.Failure(username, ip); err != nil {
+				log.Println("Error recording failed sign-in: ", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.throttle.Success(user.Username); err != nil {
+		log.Println("Error resetting sign-in attempts: ", err)
+	}
+
+	tokens, err := h.jwtService.IssueTokens(user, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func clientInfo(c *gin.Context) models.ClientInfo {
+	return models.ClientInfo{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
+func respondThrottled(c *gin.Context, err error) {
+	var lockout *service.LockoutError
+	if errors.As(err, &lockout) {
+		retryAfter := int(math.Ceil(lockout.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// @Summary      Refresh tokens
+// @Description  Exchange a refresh token for a new access token and a rotated refresh token
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.RefreshRequest  true  "Refresh token"
+// @Success      200    {object}  models.TokenResponse
+// @Failure      400    {object}  map[string]string
+// @Failure      401    {object}  map[string]string
+// @Failure      502    {object}  map[string]string
+// @Router       /auth/refresh [post]
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var input models.RefreshRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tokens, err := h.jwtService.Refresh(input.RefreshToken, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
 }
 
 // SignUp godoc
 // @Summary      Sign up
-// @Description  Register a new user with name, username, and password
+// @Description  Register a new user with name, username, email and password, and send a verification link
 // @Tags         auth
 // @Accept       json
 // @Produce      json
 // @Param        input  body      models.CreateUserRequest  true  "User registration info"
 // @Success      200  {object}  map[string]string  "Successfully registered"
 // @Failure      400  {object}  map[string]string  "Invalid input"
+// @Failure      409  {object}  map[string]string  "Username taken"
+// @Failure      422  {object}  map[string]interface{}  "Username or password rejected"
 // @Failure      502  {object}  map[string]string  "Server error"
 // @Router       /auth/sign-up [post]
 func (h *AuthHandler) SignUp(c *gin.Context) {
@@ -67,15 +232,199 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
 		return
 	}
 
-	err := h.userService.Create(&input)
+	user, err := h.userService.Create(&input)
 	if err != nil {
+		if respondValidation(c, err) || respondUsernameConflict(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadGateway, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
+	if err := h.verificationService.Send(user.ID); err != nil {
+		log.Println("Error sending verification mail on sign-up: ", err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"username": input.Username,
+		"message":  "check your inbox to verify your email",
 	})
 }
+
+// @Summary      Log out
+// @Description  End the current session. For tokens without a session, revoke the access token and, optionally, its refresh token
+// @Tags         auth
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.LogoutRequest  false  "Refresh token to revoke"
+// @Success      200    {object}  map[string]string
+// @Failure      401    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /auth/logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var input models.LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := h.jwtService.Logout(identity, input.RefreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// @Summary      Log out everywhere
+// @Description  Revoke every access and refresh token issued to the current user
+// @Tags         auth
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Success      200    {object}  map[string]string
+// @Failure      401    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /auth/logout-all [post]
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.jwtService.LogoutAll(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "logged out from all sessions"})
+}
+
+// @Summary      Forgot password
+// @Description  Email a single-use password reset link to each account with the username or verified email
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.ForgotPasswordRequest  true  "Username or email"
+// @Success      202    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      429    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var input models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.resetService.Forgot(&input, c.ClientIP()); err != nil {
+		if errors.Is(err, service.ErrResetRateLimited) {
+			c.Header("Retry-After", strconv.Itoa(int(service.PasswordResetWindow.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the account exists, a reset link has been sent"})
+}
+
+// @Summary      Reset password
+// @Description  Set a new password with a reset token and sign out all sessions
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.ResetPasswordRequest  true  "Reset token and new password"
+// @Success      200    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      422    {object}  map[string]interface{}
+// @Failure      500    {object}  map[string]string
+// @Router       /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var input models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.resetService.Reset(&input); err != nil {
+		if respondValidation(c, err) {
+			return
+		}
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password updated"})
+}
+
+// @Summary      Verify email
+// @Description  Confirm an email address with the signed link sent by mail
+// @Tags         auth
+// @Produce      json
+// @Param        token  query     string  true  "Verification token"
+// @Success      200    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /auth/verify-email [get]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	if err := h.verificationService.Verify(token); err != nil {
+		if errors.Is(err, service.ErrInvalidVerificationToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "email verified, refresh your token to get full access"})
+}
+
+// @Summary      Resend verification email
+// @Description  Send a new verification link to the current user's email
+// @Tags         auth
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Success      202  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      429  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/verify-email/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.verificationService.Send(userID); err != nil {
+		switch {
+		case errors.Is(err, service.ErrVerificationRateLimited):
+			c.Header("Retry-After", strconv.Itoa(int(service.EmailVerificationCooldown.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrEmailAlreadyVerified), errors.Is(err, service.ErrNoEmailAddress):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "verification email sent"})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/service"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	userIDCtx           = "userID"
+	usernameCtx         = "username"
+	roleCtx             = "role"
+	identityCtx         = "identity"
+)
+
+// UserIdentity verifies the bearer token, either a JWT or a personal access
+// token, and stores the caller in the context.
+func (h *AuthHandler) UserIdentity(c *gin.Context) {
+	header := c.GetHeader(authorizationHeader)
+	if header == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "empty auth header"})
+		return
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid auth header"})
+		return
+	}
+
+	var identity *service.Identity
+	var err error
+	if strings.HasPrefix(token, service.PersonalTokenPrefix) {
+		identity, err = h.patService.Authenticate(token)
+	} else {
+		identity, err = h.jwtService.ParseToken(token)
+	}
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidToken) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Set(userIDCtx, identity.UserID)
+	c.Set(usernameCtx, identity.Username)
+	c.Set(roleCtx, identity.Role)
+	c.Set(identityCtx, identity)
+	c.Next()
+}
+
+// RequirePermission rejects callers whose role lacks the given permission.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, err := getIdentity(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !identity.HasPermission(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope rejects personal access tokens that were not granted scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, err := getIdentity(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !identity.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token is missing scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail limits accounts that have not confirmed their email yet to read-only access.
+func RequireVerifiedEmail(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if !identity.EmailVerified {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "please verify your email address first"})
+		return
+	}
+	c.Next()
+}
+
+// RequireSession rejects personal access tokens on routes that manage
+// credentials, so a leaked token cannot mint or revoke other tokens.
+func RequireSession(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if identity.IsPersonalToken() {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "personal access tokens cannot be used here"})
+		return
+	}
+	c.Next()
+}
+
+func getUserID(c *gin.Context) (uuid.UUID, error) {
+	id, ok := c.Get(userIDCtx)
+	if !ok {
+		return uuid.Nil, errors.New("user id not found")
+	}
+	userID, ok := id.(uuid.UUID)
+	if !ok {
+		return uuid.Nil, errors.New("user id is of invalid type")
+	}
+	return userID, nil
+}
+
+func getIdentity(c *gin.Context) (*service.Identity, error) {
+	value, ok := c.Get(identityCtx)
+	if !ok {
+		return nil, errors.New("identity not found")
+	}
+	identity, ok := value.(*service.Identity)
+	if !ok {
+		return nil, errors.New("identity is of invalid type")
+	}
+	return identity, nil
+}
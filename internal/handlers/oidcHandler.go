@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qsheker/ToDo-app/internal/service"
+)
+
+const (
+	oidcLoginCookie     = "oidc_login"
+	oidcLoginCookiePath = "/auth/oidc"
+)
+
+type OIDCHandler struct {
+	service    service.OIDCService
+	jwtService service.JwtService
+}
+
+func NewOIDCHandler(s service.OIDCService, jwtService service.JwtService) *OIDCHandler {
+	return &OIDCHandler{service: s, jwtService: jwtService}
+}
+
+// @Summary      Sign in with SSO
+// @Description  Redirect to the identity provider to start an OpenID Connect sign-in
+// @Tags         auth
+// @Success      302
+// @Failure      500  {object}  map[string]string
+// @Router       /auth/oidc/login [get]
+func (h *OIDCHandler) Login(c *gin.Context) {
+	login, err := h.service.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Lax so the cookie comes back on the provider's redirect to the callback.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLoginCookie, login.State, 600, oidcLoginCookiePath, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, login.AuthURL)
+}
+
+// @Summary      SSO callback
+// @Description  Complete an OpenID Connect sign-in and return the app's own tokens
+// @Tags         auth
+// @Produce      json
+// @Param        code   query     string  true  "Authorization code"
+// @Param        state  query     string  true  "State from the login redirect"
+// @Success      200    {object}  models.TokenResponse
+// @Failure      400    {object}  map[string]string
+// @Failure      403    {object}  map[string]string
+// @Failure      409    {object}  map[string]string
+// @Failure      502    {object}  map[string]string
+// @Router       /auth/oidc/callback [get]
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": providerErr, "description": c.Query("error_description")})
+		return
+	}
+
+	login, err := c.Cookie(oidcLoginCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": service.ErrInvalidOIDCLogin.Error()})
+		return
+	}
+	c.SetCookie(oidcLoginCookie, "", -1, oidcLoginCookiePath, "", c.Request.TLS != nil, true)
+
+	user, err := h.service.Complete(c.Request.Context(), login, c.Query("state"), c.Query("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidOIDCLogin):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrOIDCAccountNotFound):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrOIDCEmailConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	tokens, err := h.jwtService.IssueTokens(user, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
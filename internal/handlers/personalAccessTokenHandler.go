@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/service"
+)
+
+type PersonalAccessTokenHandler struct {
+	service service.PersonalAccessTokenService
+}
+
+func NewPersonalAccessTokenHandler(s service.PersonalAccessTokenService) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{service: s}
+}
+
+// @Summary      Create a personal access token
+// @Description  Create a named, scoped token for scripts. The token is only returned once.
+// @Tags         tokens
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.CreatePersonalAccessTokenRequest  true  "Token settings"
+// @Success      201    {object}  models.CreatePersonalAccessTokenResponse
+// @Failure      400    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /users/me/tokens [post]
+func (h *PersonalAccessTokenHandler) Create(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := h.service.Create(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+// @Summary      List personal access tokens
+// @Description  List the current user's personal access tokens without their secrets
+// @Tags         tokens
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Success      200  {array}   models.PersonalAccessToken
+// @Failure      500  {object}  map[string]string
+// @Router       /users/me/tokens [get]
+func (h *PersonalAccessTokenHandler) List(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.service.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// @Summary      Revoke a personal access token
+// @Description  Revoke one of the current user's personal access tokens
+// @Tags         tokens
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id   path      string  true  "Token ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /users/me/tokens/{id} [delete]
+func (h *PersonalAccessTokenHandler) Revoke(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid"})
+		return
+	}
+
+	if err := h.service.Revoke(userID, id); err != nil {
+		if errors.Is(err, service.ErrPersonalTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/service"
+)
+
+type ReminderHandler struct {
+	service service.ReminderService
+}
+
+func NewReminderHandler(s service.ReminderService) *ReminderHandler {
+	return &ReminderHandler{service: s}
+}
+
+// @Summary      Add a reminder
+// @Description  Remind about a todo at a fixed time, or a number of minutes before its due date
+// @Tags         reminders
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        id     path      int                     true  "Todo ID"
+// @Param        input  body      models.ReminderRequest  true  "When to remind"
+// @Success      201    {object}  models.Reminder
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Failure      422    {object}  map[string]interface{}
+// @Router       /todos/{id}/reminders [post]
+func (h *ReminderHandler) Create(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	todoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+
+	var req models.ReminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reminder, err := h.service.Create(userID, todoID, &req)
+	if err != nil {
+		if respondValidation(c, err) {
+			return
+		}
+		c.JSON(reminderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, reminder)
+}
+
+// @Summary      List reminders
+// @Description  List the reminders of a todo, including those already sent
+// @Tags         reminders
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id   path      int  true  "Todo ID"
+// @Success      200  {array}   models.Reminder
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /todos/{id}/reminders [get]
+func (h *ReminderHandler) List(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	todoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+
+	reminders, err := h.service.List(userID, todoID)
+	if err != nil {
+		c.JSON(reminderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reminders)
+}
+
+// @Summary      Delete a reminder
+// @Tags         reminders
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id          path      int  true  "Todo ID"
+// @Param        reminderID  path      int  true  "Reminder ID"
+// @Success      200         {object}  map[string]string
+// @Failure      400         {object}  map[string]string
+// @Failure      404         {object}  map[string]string
+// @Router       /todos/{id}/reminders/{reminderID} [delete]
+func (h *ReminderHandler) Delete(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	todoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("reminderID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid reminder ID"})
+		return
+	}
+
+	if err := h.service.Delete(userID, todoID, id); err != nil {
+		c.JSON(reminderErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "reminder deleted"})
+}
+
+func reminderErrorStatus(err error) int {
+	if errors.Is(err, service.ErrTodoNotFound) || errors.Is(err, service.ErrReminderNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
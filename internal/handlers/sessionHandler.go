@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/service"
+)
+
+type SessionHandler struct {
+	jwtService service.JwtService
+}
+
+func NewSessionHandler(jwtService service.JwtService) *SessionHandler {
+	return &SessionHandler{jwtService: jwtService}
+}
+
+// @Summary      List sessions
+// @Description  List the devices the current user is signed in on
+// @Tags         sessions
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Success      200  {array}   models.SessionResponse
+// @Failure      500  {object}  map[string]string
+// @Router       /users/me/sessions [get]
+func (h *SessionHandler) List(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessions, err := h.jwtService.Sessions(identity.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := make([]models.SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = models.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == identity.SessionID,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary      Revoke a session
+// @Description  Sign the current user out on one device
+// @Tags         sessions
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id   path      string  true  "Session ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /users/me/sessions/{id} [delete]
+func (h *SessionHandler) Revoke(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid"})
+		return
+	}
+
+	if err := h.jwtService.RevokeSession(userID, id); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/service"
+)
+
+type TagHandler struct {
+	service service.TagService
+}
+
+func NewTagHandler(s service.TagService) *TagHandler {
+	return &TagHandler{service: s}
+}
+
+// @Summary      List tags
+// @Description  List your tags by name, each with the number of todos that carry it and how many of those are open
+// @Tags         tags
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Success      200  {array}   models.TagSummary
+// @Failure      500  {object}  map[string]string
+// @Router       /tags [get]
+func (h *TagHandler) List(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags, err := h.service.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+// @Summary      Create a tag
+// @Tags         tags
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.TagRequest  true  "Name and color"
+// @Success      201    {object}  models.Tag
+// @Failure      400    {object}  map[string]string
+// @Failure      409    {object}  map[string]string
+// @Failure      422    {object}  map[string]interface{}  "Name rejected"
+// @Router       /tags [post]
+func (h *TagHandler) Create(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag, err := h.service.Create(userID, &req)
+	if err != nil {
+		if respondValidation(c, err) {
+			return
+		}
+		c.JSON(tagErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, tag)
+}
+
+// @Summary      Update a tag
+// @Description  Rename or recolor a tag; every todo that carries it shows the change
+// @Tags         tags
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        id     path      int                true  "Tag ID"
+// @Param        input  body      models.TagRequest  true  "Name and color"
+// @Success      200    {object}  models.Tag
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Failure      409    {object}  map[string]string
+// @Failure      422    {object}  map[string]interface{}  "Name rejected"
+// @Router       /tags/{id} [put]
+func (h *TagHandler) Update(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+
+	var req models.TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag, err := h.service.Update(userID, id, &req)
+	if err != nil {
+		if respondValidation(c, err) {
+			return
+		}
+		c.JSON(tagErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tag)
+}
+
+// @Summary      Delete a tag
+// @Description  Delete a tag and take it off every todo that carries it
+// @Tags         tags
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id   path      int  true  "Tag ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /tags/{id} [delete]
+func (h *TagHandler) Delete(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+
+	if err := h.service.Delete(userID, id); err != nil {
+		c.JSON(tagErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "tag deleted"})
+}
+
+// @Summary      Tag a todo
+// @Tags         tags
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id     path      int  true  "Todo ID"
+// @Param        tagID  path      int  true  "Tag ID"
+// @Success      200    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Router       /todos/{id}/tags/{tagID} [put]
+func (h *TagHandler) Attach(c *gin.Context) {
+	h.changeTodoTag(c, h.service.Attach, "tag attached")
+}
+
+// @Summary      Untag a todo
+// @Tags         tags
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id     path      int  true  "Todo ID"
+// @Param        tagID  path      int  true  "Tag ID"
+// @Success      200    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Router       /todos/{id}/tags/{tagID} [delete]
+func (h *TagHandler) Detach(c *gin.Context) {
+	h.changeTodoTag(c, h.service.Detach, "tag detached")
+}
+
+func (h *TagHandler) changeTodoTag(c *gin.Context, change func(userID uuid.UUID, todoID, tagID int64) error, message string) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	todoID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+	tagID, err := strconv.ParseInt(c.Param("tagID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tag ID"})
+		return
+	}
+
+	if err := change(userID, todoID, tagID); err != nil {
+		c.JSON(tagErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func tagErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrTagNotFound), errors.Is(err, service.ErrTodoNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrTagExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
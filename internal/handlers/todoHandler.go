@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -21,6 +22,7 @@ func NewTodoHandler(s service.TodoService) *TodoHandler {
 // @Summary      Create a new todo
 // @Description  Create a new todo task with title, description and completion status
 // @Tags         todos
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        input  body      models.TodoRequest  true  "Todo data"
@@ -29,14 +31,23 @@ func NewTodoHandler(s service.TodoService) *TodoHandler {
 // @Failure      500    {object}  map[string]string
 // @Router       /todos [post]
 func (h *TodoHandler) CreateTodo(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	var req models.TodoRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	todo, err := h.service.CreateTodo(&req)
+	todo, err := h.service.CreateTodo(userID, &req)
 	if err != nil {
+		if respondValidation(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -47,6 +58,7 @@ func (h *TodoHandler) CreateTodo(c *gin.Context) {
 // @Summary      Get todo by ID
 // @Description  Retrieve a todo item by its ID
 // @Tags         todos
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        id   path      int  true  "Todo ID"
@@ -55,6 +67,12 @@ func (h *TodoHandler) CreateTodo(c *gin.Context) {
 // @Failure      404  {object}  map[string]string
 // @Router       /todos/{id} [get]
 func (h *TodoHandler) GetTodoByID(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	idParam := c.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
@@ -63,44 +81,69 @@ func (h *TodoHandler) GetTodoByID(c *gin.Context) {
 		})
 		return
 	}
-	todo, err := h.service.GetTodoByID(id)
+	todo, err := h.service.GetTodoByID(userID, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "invalid id",
+		c.JSON(todoErrorStatus(err), gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 	c.JSON(http.StatusFound, todo)
 }
 
-// @Summary      Get all todos
-// @Description  Retrieve all todo items from database
+// @Summary      Get my todos
+// @Description  Retrieve the authenticated user's todos one page at a time, optionally filtered and sorted
 // @Tags         todos
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
-// @Success      200  {array}   models.Todo
-// @Failure      400  {object}  map[string]string
+// @Param        cursor          query     string  false  "next_cursor or prev_cursor of another page with the same sort"
+// @Param        limit           query     int     false  "Page size, at most 100"  default(20)
+// @Param        sort            query     string  false  "Comma-separated fields out of created_at, updated_at, title, completed, priority, rank (manual order) and id; prefix with - for descending"  default(-created_at)
+// @Param        completed       query     bool    false  "Only completed or only open todos"
+// @Param        created_after   query     string  false  "Created at or after this RFC 3339 time"
+// @Param        created_before  query     string  false  "Created before this RFC 3339 time"
+// @Param        updated_after   query     string  false  "Updated at or after this RFC 3339 time"
+// @Param        updated_before  query     string  false  "Updated before this RFC 3339 time"
+// @Param        title           query     string  false  "Title contains this text, ignoring case"
+// @Param        overdue         query     bool    false  "Only open todos past their due date, or only the others"
+// @Param        tags_any        query     string  false  "Comma-separated tag names; todos with at least one of them"
+// @Param        tags_all        query     string  false  "Comma-separated tag names; todos with all of them"
+// @Success      200             {object}  models.TodoPage
+// @Failure      400             {object}  map[string]string
 // @Router       /todos [get]
 func (h *TodoHandler) GetAllTodo(c *gin.Context) {
-	todos, err := h.service.GetAllTodos()
+	userID, err := getUserID(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, todos)
+
+	h.listTodos(c, userID)
 }
 
 // @Summary      Get todos by user ID
-// @Description  Retrieve all todos that belong to a specific user
+// @Description  Retrieve the todos that belong to a specific user one page at a time, optionally filtered and sorted (admin only)
 // @Tags         todos
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
-// @Param        userID   path      string  true  "User UUID"
-// @Success      200      {array}   models.Todo
-// @Failure      400      {object}  map[string]string
-// @Failure      404      {object}  map[string]string
+// @Param        userID          path      string  true   "User UUID"
+// @Param        cursor          query     string  false  "next_cursor or prev_cursor of another page with the same sort"
+// @Param        limit           query     int     false  "Page size, at most 100"  default(20)
+// @Param        sort            query     string  false  "Comma-separated fields out of created_at, updated_at, title, completed, priority, rank (manual order) and id; prefix with - for descending"  default(-created_at)
+// @Param        completed       query     bool    false  "Only completed or only open todos"
+// @Param        created_after   query     string  false  "Created at or after this RFC 3339 time"
+// @Param        created_before  query     string  false  "Created before this RFC 3339 time"
+// @Param        updated_after   query     string  false  "Updated at or after this RFC 3339 time"
+// @Param        updated_before  query     string  false  "Updated before this RFC 3339 time"
+// @Param        title           query     string  false  "Title contains this text, ignoring case"
+// @Param        overdue         query     bool    false  "Only open todos past their due date, or only the others"
+// @Param        tags_any        query     string  false  "Comma-separated tag names; todos with at least one of them"
+// @Param        tags_all        query     string  false  "Comma-separated tag names; todos with all of them"
+// @Success      200             {object}  models.TodoPage
+// @Failure      400             {object}  map[string]string
+// @Failure      403             {object}  map[string]string
 // @Router       /todos/user/{userID} [get]
 func (h *TodoHandler) GetTodosByUserID(c *gin.Context) {
 	userIDParam := c.Param("userID")
@@ -110,27 +153,79 @@ func (h *TodoHandler) GetTodosByUserID(c *gin.Context) {
 		return
 	}
 
-	todos, err := h.service.GetTodosByUserID(userID)
+	h.listTodos(c, userID)
+}
+
+// listTodos answers with the page of the user's todos that the query
+// parameters ask for.
+func (h *TodoHandler) listTodos(c *gin.Context, userID uuid.UUID) {
+	var params models.TodoListParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.service.ListTodos(userID, &params)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.JSON(todoErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// @Summary      Search my todos
+// @Description  Full-text search over the titles and descriptions of the authenticated user's todos, best match first.
+// @Description  Words are stemmed, "quoted phrases" match in order, -word excludes, "or" matches either side and word* matches by prefix.
+// @Description  Highlights are HTML: the text is escaped and matches are wrapped in <mark> tags.
+// @Tags         todos
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        q      query     string  true   "Search query"
+// @Param        limit  query     int     false  "Number of results, at most 100"  default(20)
+// @Success      200    {array}   models.TodoSearchResult
+// @Failure      400    {object}  map[string]string
+// @Router       /todos/search [get]
+func (h *TodoHandler) SearchTodos(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var params models.TodoSearchParams
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, todos)
+	results, err := h.service.SearchTodos(userID, &params)
+	if err != nil {
+		c.JSON(todoErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, results)
 }
 
 // @Summary      Update a todo
 // @Description  Update an existing todo's title or description
 // @Tags         todos
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        id     path      int                  true  "Todo ID"
 // @Param        input  body      models.TodoRequest    true  "Updated todo data"
 // @Success      200    {object}  models.Todo
 // @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
 // @Failure      500    {object}  map[string]string
 // @Router       /todos/{id} [put]
 func (h *TodoHandler) UpdateTodo(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	idParam := c.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
@@ -144,9 +239,12 @@ func (h *TodoHandler) UpdateTodo(c *gin.Context) {
 		return
 	}
 
-	todo, err := h.service.UpdateTodo(id, &req)
+	todo, err := h.service.UpdateTodo(userID, id, &req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if respondValidation(c, err) {
+			return
+		}
+		c.JSON(todoErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -156,14 +254,22 @@ func (h *TodoHandler) UpdateTodo(c *gin.Context) {
 // @Summary      Delete a todo
 // @Description  Delete a todo by its ID
 // @Tags         todos
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        id   path      int  true  "Todo ID"
 // @Success      200  {object}  map[string]string
 // @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /todos/{id} [delete]
 func (h *TodoHandler) DeleteTodo(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	idParam := c.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
@@ -171,8 +277,8 @@ func (h *TodoHandler) DeleteTodo(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.DeleteTodo(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.service.DeleteTodo(userID, id); err != nil {
+		c.JSON(todoErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -182,14 +288,22 @@ func (h *TodoHandler) DeleteTodo(c *gin.Context) {
 // @Summary      Toggle todo completion
 // @Description  Mark todo as complete or incomplete
 // @Tags         todos
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        id   path      int  true  "Todo ID"
 // @Success      200  {object}  models.Todo
 // @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
 // @Failure      500  {object}  map[string]string
 // @Router       /todos/{id}/toggle [patch]
 func (h *TodoHandler) ToggleComplete(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	idParam := c.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
@@ -197,11 +311,62 @@ func (h *TodoHandler) ToggleComplete(c *gin.Context) {
 		return
 	}
 
-	todo, err := h.service.ToggleComplete(id)
+	todo, err := h.service.ToggleComplete(userID, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(todoErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, todo)
+}
+
+// @Summary      Move a todo
+// @Description  Place a todo right after after_id and before before_id in your manual order (sort=rank). Give one of them to move it next to that todo, e.g. only after_id of the last todo to move it to the end.
+// @Tags         todos
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        id     path      int                     true  "Todo ID"
+// @Param        input  body      models.MoveTodoRequest  true  "New neighbours"
+// @Success      200    {object}  models.TodoResponse
+// @Failure      400    {object}  map[string]string
+// @Failure      404    {object}  map[string]string
+// @Failure      500    {object}  map[string]string
+// @Router       /todos/{id}/move [post]
+func (h *TodoHandler) MoveTodo(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ID"})
+		return
+	}
+
+	var req models.MoveTodoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	todo, err := h.service.MoveTodo(userID, id, &req)
+	if err != nil {
+		c.JSON(todoErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, todo)
 }
+
+func todoErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrTodoNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidCursor), errors.Is(err, service.ErrInvalidSort),
+		errors.Is(err, service.ErrEmptySearch), errors.Is(err, service.ErrInvalidMove):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
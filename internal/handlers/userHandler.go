@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"net/http"
+	"net/url"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -10,21 +13,26 @@ import (
 )
 
 type UserHandler struct {
-	service service.UserService
+	service        service.UserService
+	throttle       service.LoginThrottle
+	accountService service.AccountService
 }
 
-func NewUserHandler(s service.UserService) *UserHandler {
-	return &UserHandler{service: s}
+func NewUserHandler(s service.UserService, throttle service.LoginThrottle, accountService service.AccountService) *UserHandler {
+	return &UserHandler{service: s, throttle: throttle, accountService: accountService}
 }
 
 // @Summary      Create a new user
-// @Description  Register a new user with name, username, and password
+// @Description  Register a new user with name, username, and password (admin only)
 // @Tags         users
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        input  body      models.CreateUserRequest  true  "User info"
 // @Success      201    {object}  map[string]string
 // @Failure      400    {object}  map[string]string
+// @Failure      409    {object}  map[string]string
+// @Failure      422    {object}  map[string]interface{}  "Username or password rejected"
 // @Failure      500    {object}  map[string]string
 // @Router       /users [post]
 func (h *UserHandler) CreateUser(c *gin.Context) {
@@ -34,7 +42,10 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.Create(&req); err != nil {
+	if _, err := h.service.Create(&req); err != nil {
+		if respondValidation(c, err) || respondUsernameConflict(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -45,6 +56,7 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 // @Summary      Get user by ID
 // @Description  Retrieve a user by their unique UUID
 // @Tags         users
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        id   path      string  true  "User ID"
@@ -69,18 +81,26 @@ func (h *UserHandler) GetUserById(c *gin.Context) {
 }
 
 // @Summary      Get user by Username
-// @Description  Retrieve a user by Username
+// @Description  Retrieve a user by Username, ignoring case. A name the user has changed redirects to the current one.
 // @Tags         users
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        username   path      string  true  "Username"
 // @Success      200  {object}  models.UserResponse
+// @Success      302  {string}  string  "Redirect to the current username"
 // @Failure      404  {object}  map[string]string
-// @Router       /users/{username} [get]
+// @Router       /users/username/{username} [get]
 func (h *UserHandler) GetByUsername(c *gin.Context) {
 	usernameParam := c.Param("username")
 	user, err := h.service.GetByUsername(usernameParam)
 	if err != nil {
+		var moved *service.UsernameMovedError
+		if errors.As(err, &moved) {
+			// Not permanent: the old name can be taken by someone else later.
+			c.Redirect(http.StatusFound, "/users/username/"+url.PathEscape(moved.Username))
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "invalid username",
 		})
@@ -90,15 +110,26 @@ func (h *UserHandler) GetByUsername(c *gin.Context) {
 }
 
 // @Summary      Update user info
-// @Description  Update name, username or password for a user
+// @Description  Update name, username, email or password of your own account, or of any account as an admin. Omit id to update your own account.
+// @Description  Changing your own password or email takes current_password. Either change signs the account out everywhere.
 // @Tags         users
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        input  body      models.UpdateUserRequest  true  "Updated user info"
 // @Success      200    {object}  map[string]string
 // @Failure      400    {object}  map[string]string
+// @Failure      403    {object}  map[string]string
+// @Failure      409    {object}  map[string]string
+// @Failure      422    {object}  map[string]interface{}  "Username or password rejected"
 // @Router       /users [put]
 func (h *UserHandler) Update(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	var user models.UpdateUserRequest
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -106,7 +137,17 @@ func (h *UserHandler) Update(c *gin.Context) {
 		})
 		return
 	}
-	if err := h.service.Update(&user); err != nil {
+	if user.ID == uuid.Nil {
+		user.ID = identity.UserID
+	}
+	if !identity.CanManageUser(user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	if err := h.service.Update(identity.UserID, &user); err != nil {
+		if respondValidation(c, err) || respondUsernameConflict(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
@@ -117,29 +158,198 @@ func (h *UserHandler) Update(c *gin.Context) {
 	})
 }
 
+// @Summary      Change username
+// @Description  Change your username. The old name stays reserved for you for a while and lookups of it redirect to the new one.
+// @Tags         users
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        input  body      models.ChangeUsernameRequest  true  "New username"
+// @Success      200    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      409    {object}  map[string]string
+// @Failure      422    {object}  map[string]interface{}  "Username rejected"
+// @Router       /users/me/username [put]
+func (h *UserHandler) ChangeUsername(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.ChangeUsernameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.ChangeUsername(identity.UserID, req.Username); err != nil {
+		if respondValidation(c, err) || respondUsernameConflict(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "username changed"})
+}
+
 // @Summary      Delete user by ID
-// @Description  Delete user from database by their UUID
+// @Description  Schedule your own account, or any account as an admin, for deletion.
+// @Description  The account is signed out everywhere and purged with all its data once
+// @Description  the grace period is over. Signing in again before then cancels the deletion.
 // @Tags         users
+// @Security     ApiKeyAuth
 // @Accept       json
 // @Produce      json
 // @Param        id   path      string  true  "User ID"
-// @Success      200  {object}  map[string]string
+// @Success      202  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
 // @Router       /users/{id} [delete]
 func (h *UserHandler) Delete(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
 	idParam := c.Param("id")
 	id, err := uuid.Parse(idParam)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid"})
 		return
 	}
-	if err := h.service.Delete(id); err != nil {
+	if !identity.CanManageUser(id) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	dueAt, err := h.accountService.ScheduleDeletion(id)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"message": "deleted successfully",
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":         "account scheduled for deletion",
+		"deletion_due_at": dueAt,
 	})
 }
+
+// @Summary      Export my data
+// @Description  Download a ZIP archive with your profile, todos, sessions, tokens and linked identities as JSON
+// @Tags         users
+// @Security     ApiKeyAuth
+// @Produce      application/zip
+// @Success      200  {file}    file
+// @Failure      401  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /users/me/export [get]
+func (h *UserHandler) Export(c *gin.Context) {
+	identity, err := getIdentity(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Buffered so a failure halfway through still gets a proper error response.
+	var archive bytes.Buffer
+	if err := h.accountService.Export(identity.UserID, &archive); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="todo-export.zip"`)
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// @Summary      Change user role
+// @Description  Assign a role to a user (admin only). Signs the user out everywhere and revokes their personal access tokens. Not available to personal access tokens.
+// @Tags         users
+// @Security     ApiKeyAuth
+// @Accept       json
+// @Produce      json
+// @Param        id     path      string                    true  "User ID"
+// @Param        input  body      models.UpdateRoleRequest  true  "New role"
+// @Success      200    {object}  map[string]string
+// @Failure      400    {object}  map[string]string
+// @Failure      403    {object}  map[string]string
+// @Router       /users/{id}/role [put]
+func (h *UserHandler) UpdateRole(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid"})
+		return
+	}
+
+	var req models.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.UpdateRole(id, req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "role updated"})
+}
+
+// @Summary      Unlock a user
+// @Description  Clear failed sign-in attempts and any lockout on the account (admin only)
+// @Tags         users
+// @Security     ApiKeyAuth
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /users/{id}/unlock [post]
+func (h *UserHandler) Unlock(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid"})
+		return
+	}
+
+	if err := h.throttle.Unlock(id); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "user unlocked"})
+}
+
+// respondUsernameConflict answers 409 if err says the username is taken or
+// reserved and reports whether it did.
+func respondUsernameConflict(c *gin.Context, err error) bool {
+	if !errors.Is(err, service.ErrUsernameTaken) && !errors.Is(err, service.ErrUsernameReserved) {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	return true
+}
+
+// respondValidation answers 422 with field-level details if err is a
+// *service.ValidationError and reports whether it did.
+func respondValidation(c *gin.Context, err error) bool {
+	var verr *service.ValidationError
+	if !errors.As(err, &verr) {
+		return false
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "validation failed", "fields": verr.Fields})
+	return true
+}
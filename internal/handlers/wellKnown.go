@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qsheker/ToDo-app/internal/service"
+)
+
+type WellKnownHandler struct {
+	jwtService service.JwtService
+}
+
+func NewWellKnownHandler(jwtService service.JwtService) *WellKnownHandler {
+	return &WellKnownHandler{jwtService: jwtService}
+}
+
+// @Summary      JSON Web Key Set
+// @Description  Public keys that verify access tokens signed with RS256 or EdDSA
+// @Tags         well-known
+// @Produce      json
+// @Success      200  {object}  models.JSONWebKeySet
+// @Router       /.well-known/jwks.json [get]
+func (h *WellKnownHandler) JWKS(c *gin.Context) {
+	// Keep this short so verifiers pick up a newly added key soon after rotation.
+	c.Header("Cache-Control", "public, max-age=300, must-revalidate")
+	c.JSON(http.StatusOK, h.jwtService.JWKS())
+}
+
+// @Summary      OpenID discovery document
+// @Description  Issuer, signing algorithms and endpoints of this token issuer
+// @Tags         well-known
+// @Produce      json
+// @Success      200  {object}  models.OpenIDConfiguration
+// @Router       /.well-known/openid-configuration [get]
+func (h *WellKnownHandler) OpenIDConfiguration(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, h.jwtService.Discovery())
+}
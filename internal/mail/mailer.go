@@ -0,0 +1,15 @@
+package mail
+
+// Message is a plain-text mail. ID, if set, becomes the local part of the
+// Message-ID header, so that sending the same message twice can be detected.
+type Message struct {
+	ID      string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain-text messages.
+type Mailer interface {
+	Send(msg Message) error
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type outboxMailer struct {
+	mu   sync.Mutex
+	from string
+	dir  string
+}
+
+// NewOutboxMailer writes every message to dir as an .eml file, or to stdout
+// when dir is empty. It is meant for local development without a mail server.
+func NewOutboxMailer(from, dir string) Mailer {
+	return &outboxMailer{from: from, dir: dir}
+}
+
+func (m *outboxMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data := compose(m.from, msg)
+	if m.dir == "" {
+		_, err := fmt.Fprintf(os.Stdout, "----- outgoing mail -----\n%s\n-------------------------\n", data)
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o600)
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExternalIdentity links a user to an account at an OpenID Connect
+// provider, identified by the provider's issuer and the sub claim.
+type ExternalIdentity struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	Issuer    string    `json:"issuer" gorm:"type:varchar(255);not null;uniqueIndex:idx_external_identity_subject"`
+	Subject   string    `json:"subject" gorm:"type:varchar(255);not null;uniqueIndex:idx_external_identity_subject"`
+	Email     string    `json:"email,omitempty" gorm:"type:varchar(255)"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginAttempt counts recent failed sign-ins for a key such as a username
+// or client IP.
+type LoginAttempt struct {
+	Key           string `gorm:"primaryKey"`
+	Failures      int    `gorm:"not null;default:0"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+	ExpiresAt     time.Time `gorm:"index"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// NotificationDelivery remembers a notification by its idempotency key so
+// that it is delivered once. ClaimedUntil is set while a sender is
+// delivering it and DeliveredAt once it went out.
+type NotificationDelivery struct {
+	Key          string `gorm:"primaryKey"`
+	ClaimedUntil *time.Time
+	DeliveredAt  *time.Time
+	ExpiresAt    time.Time `gorm:"index"`
+}
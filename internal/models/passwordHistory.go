@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordHistory keeps the hashes of a user's previous passwords so they
+// cannot be reused.
+type PasswordHistory struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Hash      string    `gorm:"type:varchar(255);not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
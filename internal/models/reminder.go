@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reminder fires once at FireAt. It is set either at an absolute RemindAt
+// or MinutesBeforeDue the todo's due date, in which case FireAt follows the
+// due date around and is nil while the todo has none. ClaimedUntil is set
+// while a scheduler is sending it, and FailedAt once it gave up on it.
+type Reminder struct {
+	ID               int64      `json:"id" gorm:"primaryKey;autoIncrement"`
+	TodoID           int64      `json:"todo_id" gorm:"not null;index"`
+	UserID           uuid.UUID  `json:"-" gorm:"type:uuid;not null;index"`
+	RemindAt         *time.Time `json:"remind_at,omitempty"`
+	MinutesBeforeDue *int       `json:"minutes_before_due,omitempty"`
+	FireAt           *time.Time `json:"fire_at,omitempty" gorm:"index"`
+	SentAt           *time.Time `json:"sent_at,omitempty"`
+	FailedAt         *time.Time `json:"failed_at,omitempty"`
+	ClaimedUntil     *time.Time `json:"-"`
+	Attempts         int        `json:"-" gorm:"not null;default:0"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// ReminderRequest sets exactly one of RemindAt and MinutesBeforeDue.
+type ReminderRequest struct {
+	RemindAt         *time.Time `json:"remind_at" binding:"required_without=MinutesBeforeDue,excluded_with=MinutesBeforeDue"`
+	MinutesBeforeDue *int       `json:"minutes_before_due" binding:"omitempty,min=0,max=525600"`
+}
+
+// FireTime returns when a reminder set this way should fire for a todo due
+// at dueAt, or nil if it cannot fire.
+func (r *Reminder) FireTime(dueAt *time.Time) *time.Time {
+	if r.RemindAt != nil {
+		return r.RemindAt
+	}
+	if r.MinutesBeforeDue == nil || dueAt == nil {
+		return nil
+	}
+	fireAt := dueAt.Add(-time.Duration(*r.MinutesBeforeDue) * time.Minute)
+	return &fireAt
+}
@@ -0,0 +1,32 @@
+package models
+
+import "slices"
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+const (
+	PermissionTodosReadAll = "todos.read_all"
+	PermissionUsersManage  = "users.manage"
+)
+
+// rolePermissions grants permissions on top of what every signed-in user
+// can do with their own account and todos.
+var rolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermissionTodosReadAll,
+		PermissionUsersManage,
+	},
+	RoleMember: {},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func RoleHasPermission(role, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tag is a label a user can put on any number of their todos. Todos refer
+// to tags by ID, so renaming one shows up on all of its todos at once.
+type Tag struct {
+	ID        int64     `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID    uuid.UUID `json:"-" gorm:"type:uuid;not null;uniqueIndex:idx_tags_user_name,priority:1"`
+	Name      string    `json:"name" gorm:"type:varchar(64);not null;uniqueIndex:idx_tags_user_name,priority:2"`
+	Color     string    `json:"color" gorm:"type:varchar(7);not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TagRequest creates or changes a tag. Names may not contain commas, which
+// separate them in the tag filters of todo listings.
+type TagRequest struct {
+	Name  string `json:"name" binding:"required,max=64,excludesall=0x2C"`
+	Color string `json:"color,omitempty" binding:"omitempty,hexcolor"`
+}
+
+// TagSummary is a tag with the number of todos that carry it.
+type TagSummary struct {
+	Tag
+	TodoCount int64 `json:"todo_count"`
+	OpenCount int64 `json:"open_count"`
+}
@@ -1,37 +1,213 @@
 package models
 
 import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Todo is one task. Rank is its place in the user's manual order, see
+// package rank.
 type Todo struct {
-	ID          int64          `json:"id" gorm:"primaryKey;autoIncrement"`
+	ID          int64          `json:"id" gorm:"primaryKey;autoIncrement;index:idx_todos_user_created,priority:3"`
 	Title       string         `json:"title" gorm:"type:varchar(255);not null"`
 	Description string         `json:"description,omitempty" gorm:"type:text"`
 	Completed   bool           `json:"completed" gorm:"default:false"`
-	CreatedAt   time.Time      `json:"created_at"`
+	DueAt       *time.Time     `json:"due_at,omitempty" gorm:"index"`
+	DueTimeZone string         `json:"due_time_zone,omitempty" gorm:"type:varchar(64)"`
+	Priority    Priority       `json:"priority" gorm:"type:smallint;not null;default:0"`
+	Rank        string         `json:"-" gorm:"type:varchar(255) COLLATE \"C\";not null;default:'';index:idx_todos_user_rank,priority:2"`
+	CreatedAt   time.Time      `json:"created_at" gorm:"index:idx_todos_user_created,priority:2"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 
-	UserID uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	UserID uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index;index:idx_todos_user_created,priority:1;index:idx_todos_user_rank,priority:1"`
 	User   User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Tags   []Tag     `json:"tags,omitempty" gorm:"many2many:todo_tags"`
+}
+
+type Priority int16
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+func (p Priority) String() string {
+	if p < 0 || int(p) >= len(priorityNames) {
+		return fmt.Sprintf("Priority(%d)", int16(p))
+	}
+	return priorityNames[p]
+}
+
+// MarshalText makes priorities appear by name in JSON.
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Priority) UnmarshalText(text []byte) error {
+	index := slices.Index(priorityNames, string(text))
+	if index < 0 {
+		return fmt.Errorf("unknown priority %q, use one of none, low, medium, high or urgent", text)
+	}
+	*p = Priority(index)
+	return nil
+}
+
+// Value stores priorities as numbers so that they sort by urgency.
+func (p Priority) Value() (driver.Value, error) {
+	return int64(p), nil
 }
 
 type TodoRequest struct {
-	Title       string    `json:"title" validate:"required"`
-	Description string    `json:"description,omitempty"`
-	Completed   bool      `json:"completed"`
-	UserID      uuid.UUID `json:"user_id,omitempty"`
+	Title       string     `json:"title" binding:"required,max=255"`
+	Description string     `json:"description,omitempty"`
+	Completed   bool       `json:"completed"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	// DueTimeZone is an IANA zone such as "Europe/Berlin", UTC if empty.
+	DueTimeZone string   `json:"due_time_zone,omitempty" binding:"max=64"`
+	Priority    Priority `json:"priority,omitempty" swaggertype:"string" enums:"none,low,medium,high,urgent"`
 }
 type TodoResponse struct {
-	ID          int64     `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description,omitempty"`
-	Completed   bool      `json:"completed"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	UserID      uuid.UUID `json:"user_id"`
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	Completed   bool       `json:"completed"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	DueTimeZone string     `json:"due_time_zone,omitempty"`
+	Priority    Priority   `json:"priority" swaggertype:"string" enums:"none,low,medium,high,urgent"`
+	Tags        []Tag      `json:"tags,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	UserID      uuid.UUID  `json:"user_id"`
+}
+
+// TodoSortFields are the fields todo listings can be sorted by. They are
+// also the column names. Sorting by rank gives the manual order.
+var TodoSortFields = []string{"created_at", "updated_at", "title", "completed", "priority", "rank", "id"}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// TodoCursor is a position in a todo listing: the values of the sort fields
+// of the row it points at. Backward asks for the page before the position
+// instead of the one after it.
+type TodoCursor struct {
+	Values   []interface{}
+	Backward bool
+}
+
+type TodoFilter struct {
+	Completed     *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	TitleContains string
+	// Overdue selects open todos whose due date has passed, or all others.
+	Overdue *bool
+	Now     time.Time
+	// TagsAny selects todos with at least one of the tags, TagsAll those
+	// with every one of them. Tags are given by name.
+	TagsAny []string
+	TagsAll []string
+}
+
+// TodoListQuery selects one page of a user's todos. Sort always ends with
+// id so that every row has a distinct position. Cursor is nil for the first
+// page.
+type TodoListQuery struct {
+	Filter TodoFilter
+	Sort   []SortField
+	Cursor *TodoCursor
+	Limit  int
+}
+
+// TodoListParams are the query parameters of the todo list endpoints.
+// Times are RFC 3339.
+type TodoListParams struct {
+	Cursor        string     `form:"cursor"`
+	Limit         int        `form:"limit" binding:"omitempty,min=1"`
+	Sort          string     `form:"sort"`
+	Completed     *bool      `form:"completed"`
+	CreatedAfter  *time.Time `form:"created_after"`
+	CreatedBefore *time.Time `form:"created_before"`
+	UpdatedAfter  *time.Time `form:"updated_after"`
+	UpdatedBefore *time.Time `form:"updated_before"`
+	Title         string     `form:"title" binding:"max=255"`
+	Overdue       *bool      `form:"overdue"`
+	TagsAny       string     `form:"tags_any"`
+	TagsAll       string     `form:"tags_all"`
+}
+
+// MoveTodoRequest places a todo between two neighbours in the manual order:
+// right after AfterID and before BeforeID. Either may be left out to move it
+// to the start or the end of the list.
+type MoveTodoRequest struct {
+	AfterID  *int64 `json:"after_id"`
+	BeforeID *int64 `json:"before_id"`
+}
+
+type TodoPage struct {
+	Items      []TodoResponse `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	PrevCursor string         `json:"prev_cursor,omitempty"`
+}
+
+// TodoSearchQuery is a full-text search over one user's todos. Text uses the
+// web search syntax: words, "quoted phrases", -excluded words, "or", and
+// words ending in * to match by prefix.
+type TodoSearchQuery struct {
+	Text  string
+	Limit int
+}
+
+// HighlightStart and HighlightStop surround matches in search highlights.
+// They are private-use characters rather than HTML, so the text around them
+// can still be escaped before it is shown.
+const (
+	HighlightStart = "\uE000"
+	HighlightStop  = "\uE001"
+)
+
+// TodoSearchHit is one search result as the repository finds it. The
+// highlights are plain text with matches between HighlightStart and
+// HighlightStop.
+type TodoSearchHit struct {
+	ID                 int64
+	Title              string
+	Description        string
+	Completed          bool
+	DueAt              *time.Time
+	DueTimeZone        string
+	Priority           Priority
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	UserID             uuid.UUID
+	Rank               float64
+	TitleHighlight     string
+	DescriptionSnippet string
+}
+
+type TodoSearchResult struct {
+	TodoResponse
+	Rank               float64 `json:"rank"`
+	TitleHighlight     string  `json:"title_highlight"`
+	DescriptionSnippet string  `json:"description_snippet,omitempty"`
+}
+
+type TodoSearchParams struct {
+	Q     string `form:"q" binding:"required,max=255"`
+	Limit int    `form:"limit" binding:"omitempty,min=1"`
 }
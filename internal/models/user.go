@@ -1,43 +1,96 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 	"gorm.io/gorm"
 )
 
 type User struct {
-	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey"`
-	Name      string         `json:"name" gorm:"type:varchar(255);not null"`
-	Username  string         `json:"username" gorm:"type:varchar(255);uniqueIndex;not null"`
-	Password  string         `json:"-" gorm:"type:varchar(255);not null"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                 uuid.UUID      `json:"id" gorm:"type:uuid;primaryKey"`
+	Name               string         `json:"name" gorm:"type:varchar(255);not null"`
+	Username           string         `json:"username" gorm:"type:varchar(255);uniqueIndex;not null"`
+	UsernameKey        string         `json:"-" gorm:"type:varchar(255)"`
+	Email              string         `json:"email,omitempty" gorm:"type:varchar(255);index"`
+	EmailVerified      bool           `json:"email_verified" gorm:"not null;default:false"`
+	VerificationSentAt *time.Time     `json:"-"`
+	Password           string         `json:"-" gorm:"type:varchar(255);not null"`
+	Role               string         `json:"role" gorm:"type:varchar(32);not null;default:member"`
+	TwoFactorEnabled   bool           `json:"two_factor_enabled" gorm:"not null;default:false"`
+	TOTPSecret         string         `json:"-" gorm:"type:varchar(64)"`
+	TOTPPendingSecret  string         `json:"-" gorm:"type:varchar(64)"`
+	TOTPLastStep       int64          `json:"-" gorm:"not null;default:0"`
+	DeletionDueAt      *time.Time     `json:"deletion_due_at,omitempty" gorm:"index"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `json:"-" gorm:"index"`
 
 	Todos []Todo `json:"todos,omitempty" gorm:"foreignKey:UserID"`
 }
 
+// BeforeSave keeps UsernameKey in step with Username. The unique index on
+// username_key is what makes usernames case-insensitive.
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	u.UsernameKey = NormalizeUsername(u.Username)
+	return nil
+}
+
+// NormalizeUsername returns the key usernames are compared by: NFKC with
+// case folding, so "Alice", "ALICE" and "Ａｌｉｃｅ" are the same name.
+func NormalizeUsername(username string) string {
+	folded := cases.Fold().String(norm.NFKC.String(strings.TrimSpace(username)))
+	return norm.NFKC.String(folded)
+}
+
+// UsernameAlias remembers a name a user used to have. Until ReservedUntil
+// only that user may take the name back; lookups keep redirecting to them
+// until someone else claims it.
+type UsernameAlias struct {
+	Key           string    `gorm:"type:varchar(255);primaryKey"`
+	Username      string    `gorm:"type:varchar(255);not null"`
+	UserID        uuid.UUID `gorm:"type:uuid;not null;index"`
+	ReservedUntil time.Time `gorm:"not null"`
+	CreatedAt     time.Time
+}
+
 type CreateUserRequest struct {
-	Name     string `json:"name" validate:"required"`
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required,min=6"`
+	Name     string `json:"name" binding:"required,max=255"`
+	Username string `json:"username" binding:"required,max=255"`
+	Email    string `json:"email" binding:"required,email"`
+	// Password strength is checked by the password policy in UserService.
+	Password string `json:"password" binding:"required"`
 }
 
 type UserResponse struct {
 	ID       uuid.UUID `json:"id"`
 	Name     string    `json:"name"`
 	Username string    `json:"username"`
+	Role     string    `json:"role"`
 }
 
 type UpdateUserRequest struct {
 	ID       uuid.UUID `json:"id"`
-	Name     string    `json:"name"`
-	Username string    `json:"username"`
-	Password string    `json:"password" validate:"min=6"`
+	Name     string    `json:"name" binding:"max=255"`
+	Username string    `json:"username" binding:"max=255"`
+	Email    string    `json:"email,omitempty" binding:"omitempty,email"`
+	Password string    `json:"password"`
+	// CurrentPassword is required to change your own password or email.
+	CurrentPassword string `json:"current_password,omitempty"`
+}
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username" binding:"required,max=255"`
 }
+
 type LoginRequest struct {
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin member"`
 }
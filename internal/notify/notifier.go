@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/qsheker/ToDo-app/internal/mail"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+const (
+	// deliveryLease is how long a sender may take to deliver a reminder
+	// before another one may try again.
+	deliveryLease = 5 * time.Minute
+	// deliveryRetention is how long a delivered key is remembered.
+	deliveryRetention = 7 * 24 * time.Hour
+)
+
+// ErrDeliveryInProgress means another sender is delivering the same reminder.
+var ErrDeliveryInProgress = errors.New("reminder delivery in progress")
+
+// Reminder is what a notifier is told when a reminder fires. DueAt is in
+// the todo's own time zone. IdempotencyKey is the same every time one
+// firing is delivered, so repeats can be dropped.
+type Reminder struct {
+	IdempotencyKey string
+	ReminderID     int64
+	TodoID         int64
+	Title          string
+	DueAt          *time.Time
+	Name           string
+	Email          string
+}
+
+// Notifier delivers fired reminders to their users. An error makes the
+// scheduler try again later.
+type Notifier interface {
+	Notify(reminder Reminder) error
+}
+
+type logNotifier struct{}
+
+// NewLogNotifier only logs reminders. It is meant for local development.
+func NewLogNotifier() Notifier {
+	return logNotifier{}
+}
+
+func (logNotifier) Notify(reminder Reminder) error {
+	log.Printf("Reminder %d for todo %d %q due %v (%s)", reminder.ReminderID, reminder.TodoID, reminder.Title, reminder.DueAt, reminder.IdempotencyKey)
+	return nil
+}
+
+type mailNotifier struct {
+	mailer mail.Mailer
+}
+
+// NewMailNotifier mails reminders to the user's address. Users without one
+// are skipped. The idempotency key becomes the Message-ID, so a repeated
+// delivery shows up as the same message.
+func NewMailNotifier(mailer mail.Mailer) Notifier {
+	return &mailNotifier{mailer: mailer}
+}
+
+func (n *mailNotifier) Notify(reminder Reminder) error {
+	if reminder.Email == "" {
+		return nil
+	}
+	body := fmt.Sprintf("Hi %s,\n\nthis is your reminder for %q.\n", reminder.Name, reminder.Title)
+	if reminder.DueAt != nil {
+		body += fmt.Sprintf("It is due %s.\n", reminder.DueAt.Format("Mon, 02 Jan 2006 15:04 MST"))
+	}
+	return n.mailer.Send(mail.Message{
+		ID:      reminder.IdempotencyKey,
+		To:      reminder.Email,
+		Subject: "Reminder: " + reminder.Title,
+		Body:    body,
+	})
+}
+
+type idempotentNotifier struct {
+	next       Notifier
+	deliveries repository.DeliveryStore
+}
+
+// NewIdempotentNotifier passes each idempotency key to next once. The key
+// is claimed before sending and recorded once next succeeds, so a retry of
+// a reminder that already went out is dropped. Only a crash between next
+// accepting a reminder and the key being recorded still sends it twice.
+func NewIdempotentNotifier(next Notifier, deliveries repository.DeliveryStore) Notifier {
+	return &idempotentNotifier{next: next, deliveries: deliveries}
+}
+
+func (n *idempotentNotifier) Notify(reminder Reminder) error {
+	claimed, err := n.deliveries.Claim(reminder.IdempotencyKey, time.Now(), deliveryLease, deliveryRetention)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		delivered, err := n.deliveries.IsDelivered(reminder.IdempotencyKey)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			log.Printf("Dropping repeated delivery of reminder %d (%s)", reminder.ReminderID, reminder.IdempotencyKey)
+			return nil
+		}
+		return ErrDeliveryInProgress
+	}
+
+	if err := n.next.Notify(reminder); err != nil {
+		if releaseErr := n.deliveries.Release(reminder.IdempotencyKey); releaseErr != nil {
+			log.Printf("Error releasing reminder delivery %s: %v", reminder.IdempotencyKey, releaseErr)
+		}
+		return err
+	}
+	return n.deliveries.MarkDelivered(reminder.IdempotencyKey, time.Now())
+}
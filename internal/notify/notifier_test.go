@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeDeliveryStore struct {
+	claimedUntil map[string]time.Time
+	delivered    map[string]bool
+}
+
+func newFakeDeliveryStore() *fakeDeliveryStore {
+	return &fakeDeliveryStore{claimedUntil: map[string]time.Time{}, delivered: map[string]bool{}}
+}
+
+func (store *fakeDeliveryStore) Claim(key string, now time.Time, lease, retention time.Duration) (bool, error) {
+	if store.delivered[key] || store.claimedUntil[key].After(now) {
+		return false, nil
+	}
+	store.claimedUntil[key] = now.Add(lease)
+	return true, nil
+}
+
+func (store *fakeDeliveryStore) IsDelivered(key string) (bool, error) {
+	return store.delivered[key], nil
+}
+
+func (store *fakeDeliveryStore) MarkDelivered(key string, now time.Time) error {
+	store.delivered[key] = true
+	delete(store.claimedUntil, key)
+	return nil
+}
+
+func (store *fakeDeliveryStore) Release(key string) error {
+	delete(store.claimedUntil, key)
+	return nil
+}
+
+func (store *fakeDeliveryStore) PruneExpired(now time.Time) (int64, error) {
+	return 0, nil
+}
+
+type countingNotifier struct {
+	sent map[string]int
+	err  error
+}
+
+func (n *countingNotifier) Notify(reminder Reminder) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.sent[reminder.IdempotencyKey]++
+	return nil
+}
+
+func TestIdempotentNotifierDropsRepeats(t *testing.T) {
+	next := &countingNotifier{sent: map[string]int{}}
+	notifier := NewIdempotentNotifier(next, newFakeDeliveryStore())
+
+	for i := 0; i < 3; i++ {
+		if err := notifier.Notify(Reminder{IdempotencyKey: "reminder-1-100"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := notifier.Notify(Reminder{IdempotencyKey: "reminder-1-200"}); err != nil {
+		t.Fatal(err)
+	}
+	if next.sent["reminder-1-100"] != 1 || next.sent["reminder-1-200"] != 1 {
+		t.Fatalf("sent = %v, want each key once", next.sent)
+	}
+}
+
+func TestIdempotentNotifierRetriesFailures(t *testing.T) {
+	next := &countingNotifier{sent: map[string]int{}, err: errors.New("smtp down")}
+	notifier := NewIdempotentNotifier(next, newFakeDeliveryStore())
+
+	if err := notifier.Notify(Reminder{IdempotencyKey: "reminder-1-100"}); err == nil {
+		t.Fatal("Notify() hid the error")
+	}
+	next.err = nil
+	if err := notifier.Notify(Reminder{IdempotencyKey: "reminder-1-100"}); err != nil {
+		t.Fatal(err)
+	}
+	if next.sent["reminder-1-100"] != 1 {
+		t.Fatalf("sent = %v, want the key once", next.sent)
+	}
+}
+
+func TestIdempotentNotifierWaitsForOtherSenders(t *testing.T) {
+	next := &countingNotifier{sent: map[string]int{}}
+	store := newFakeDeliveryStore()
+	notifier := NewIdempotentNotifier(next, store)
+
+	// Another server claimed the key and has not finished yet.
+	if _, err := store.Claim("reminder-1-100", time.Now(), time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(Reminder{IdempotencyKey: "reminder-1-100"}); !errors.Is(err, ErrDeliveryInProgress) {
+		t.Fatalf("Notify() error = %v, want %v", err, ErrDeliveryInProgress)
+	}
+	if len(next.sent) != 0 {
+		t.Fatalf("sent = %v, want nothing", next.sent)
+	}
+}
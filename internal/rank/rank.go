@@ -0,0 +1,134 @@
+// Package rank makes lexicographic rank keys for manually ordered lists.
+// Keys are strings of base-36 digits that never end in '0', so there is
+// always room for another key between any two, and moving an item only
+// means giving it one new key. Adding to either end steps a digit, so keys
+// there only grow by one digit every 35 inserts; inserts between two keys
+// grow them faster. Spread hands out short, evenly spaced keys again.
+//
+// Keys must be compared byte by byte, e.g. with COLLATE "C" in Postgres.
+package rank
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+const digits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+var ErrInvalidRange = errors.New("rank keys are not in order")
+
+// Between returns a key that sorts after a and before b. An empty a means
+// the start of the list and an empty b its end.
+func Between(a, b string) (string, error) {
+	if !valid(a) || !valid(b) || b != "" && a >= b {
+		return "", ErrInvalidRange
+	}
+	return midpoint(a, b), nil
+}
+
+// midpoint assumes a < b, or b == "" for no upper bound. An empty a is
+// read as all '0's.
+func midpoint(a, b string) string {
+	if b == "" {
+		return after(a)
+	}
+	if a == "" {
+		return before(b)
+	}
+
+	// Keep the common prefix, reading a as padded with '0's.
+	n := 0
+	for n < len(b) && digitAt(a, n) == b[n] {
+		n++
+	}
+	if n > 0 {
+		return b[:n] + midpoint(tail(a, n), b[n:])
+	}
+
+	low := strings.IndexByte(digits, a[0])
+	high := strings.IndexByte(digits, b[0])
+	if high-low > 1 {
+		return string(digits[(low+high)/2])
+	}
+	// The first digits are adjacent.
+	if len(b) > 1 {
+		return b[:1]
+	}
+	return string(digits[low]) + after(tail(a, 1))
+}
+
+// after returns a short key above a by stepping up its first digit that
+// is not the last one, or by adding a digit if there is none.
+func after(a string) string {
+	if a == "" {
+		return string(digits[len(digits)/2])
+	}
+	for i := 0; i < len(a); i++ {
+		if d := strings.IndexByte(digits, a[i]); d < len(digits)-1 {
+			return a[:i] + string(digits[d+1])
+		}
+	}
+	return a + string(digits[1])
+}
+
+// before returns a short key below the non-empty key b by stepping down
+// its first digit that has room, by cutting b short, or, if b ends in its
+// only '1', by putting the highest digit after a '0' there instead.
+func before(b string) string {
+	for i := 0; i < len(b); i++ {
+		d := strings.IndexByte(digits, b[i])
+		switch {
+		case d > 1:
+			return b[:i] + string(digits[d-1])
+		case d == 1 && i < len(b)-1:
+			return b[:i+1]
+		}
+	}
+	return b[:len(b)-1] + string(digits[0]) + string(digits[len(digits)-1])
+}
+
+// Spread returns n evenly spaced keys in ascending order, all as short as
+// n allows.
+func Spread(n int) []string {
+	width := 1
+	for math.Pow(float64(len(digits)), float64(width)) < float64(n+1)*4 {
+		width++
+	}
+	space := math.Pow(float64(len(digits)), float64(width))
+
+	keys := make([]string, n)
+	for i := range keys {
+		value := uint64(space * float64(i+1) / float64(n+1))
+		key := make([]byte, width)
+		for j := width - 1; j >= 0; j-- {
+			key[j] = digits[value%uint64(len(digits))]
+			value /= uint64(len(digits))
+		}
+		keys[i] = strings.TrimRight(string(key), "0")
+	}
+	return keys
+}
+
+func valid(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(digits, key[i]) < 0 {
+			return false
+		}
+	}
+	return !strings.HasSuffix(key, "0")
+}
+
+func digitAt(key string, i int) byte {
+	if i < len(key) {
+		return key[i]
+	}
+	return '0'
+}
+
+func tail(key string, n int) string {
+	if n >= len(key) {
+		return ""
+	}
+	return key[n:]
+}
@@ -0,0 +1,141 @@
+package rank
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBetweenRejectsInvalidRanges(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"b", "a"},
+		{"a", "a"},
+		{"a0", ""},
+		{"", "b0"},
+		{"A", ""},
+		{"", "a-b"},
+	}
+	for _, tt := range tests {
+		if _, err := Between(tt.a, tt.b); !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("Between(%q, %q) error = %v, want %v", tt.a, tt.b, err, ErrInvalidRange)
+		}
+	}
+}
+
+func TestBetweenOrders(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"", ""},
+		{"", "1"},
+		{"", "01"},
+		{"", "001"},
+		{"", "1a"},
+		{"z", ""},
+		{"zz", ""},
+		{"a", "b"},
+		{"a", "a1"},
+		{"a", "a01"},
+		{"az", "b"},
+		{"a1", "a2"},
+		{"zzy", "zzz"},
+	}
+	for _, tt := range tests {
+		key := between(t, tt.a, tt.b)
+		if len(key) > len(tt.a)+len(tt.b)+2 {
+			t.Errorf("Between(%q, %q) = %q, longer than expected", tt.a, tt.b, key)
+		}
+	}
+}
+
+func TestBetweenAppendsStayShort(t *testing.T) {
+	const inserts = 1000
+	// Stepping a digit gives 35 keys per added digit.
+	const maxLength = 2 + inserts/35
+
+	last := ""
+	for i := 0; i < inserts; i++ {
+		last = between(t, last, "")
+		if len(last) > maxLength {
+			t.Fatalf("key %q after %d appends is longer than %d", last, i+1, maxLength)
+		}
+	}
+}
+
+func TestBetweenPrependsStayShort(t *testing.T) {
+	const inserts = 1000
+	const maxLength = 2 + inserts/35
+
+	first := between(t, "", "")
+	for i := 0; i < inserts; i++ {
+		first = between(t, "", first)
+		if len(first) > maxLength {
+			t.Fatalf("key %q after %d prepends is longer than %d", first, i+1, maxLength)
+		}
+	}
+}
+
+func TestBetweenRandomInserts(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	keys := []string{}
+	for i := 0; i < 5000; i++ {
+		at := random.Intn(len(keys) + 1)
+		var a, b string
+		if at > 0 {
+			a = keys[at-1]
+		}
+		if at < len(keys) {
+			b = keys[at]
+		}
+		key := between(t, a, b)
+		keys = append(keys[:at], append([]string{key}, keys[at:]...)...)
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatal("keys are not in insertion order")
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 8, 9, 35, 36, 1000, 50000} {
+		keys := Spread(n)
+		if len(keys) != n {
+			t.Fatalf("Spread(%d) returned %d keys", n, len(keys))
+		}
+		width := 1
+		for limit := len(digits); limit < (n+1)*4; limit *= len(digits) {
+			width++
+		}
+		for i, key := range keys {
+			if !valid(key) || key == "" {
+				t.Fatalf("Spread(%d)[%d] = %q is not a valid key", n, i, key)
+			}
+			if len(key) > width {
+				t.Fatalf("Spread(%d)[%d] = %q is longer than %d", n, i, key, width)
+			}
+			if i > 0 && keys[i-1] >= key {
+				t.Fatalf("Spread(%d) is not ascending at %d: %q >= %q", n, i, keys[i-1], key)
+			}
+		}
+		// Spread keys leave room to append, prepend and insert.
+		if n > 1 {
+			between(t, "", keys[0])
+			between(t, keys[n-1], "")
+			between(t, keys[0], keys[1])
+		}
+	}
+}
+
+// between calls Between and checks that the key is valid and in range.
+func between(t *testing.T, a, b string) string {
+	t.Helper()
+	key, err := Between(a, b)
+	if err != nil {
+		t.Fatalf("Between(%q, %q) error = %v", a, b, err)
+	}
+	if !valid(key) || key == "" {
+		t.Fatalf("Between(%q, %q) = %q is not a valid key", a, b, key)
+	}
+	if key <= a || b != "" && key >= b {
+		t.Fatalf("Between(%q, %q) = %q is out of range", a, b, key)
+	}
+	return key
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type gormAttemptStore struct {
+	db *gorm.DB
+}
+
+// NewAttemptStore keeps sign-in counters in Postgres so that every instance
+// sees the same failures.
+func NewAttemptStore(db *gorm.DB) AttemptStore {
+	return &gormAttemptStore{db: db}
+}
+
+func (store *gormAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (int, error) {
+	var failures int
+	err := store.db.Raw(
+		`INSERT INTO login_attempts (key, failures, last_failure_at, expires_at)
+			VALUES (?, 1, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+				last_failure_at = EXCLUDED.last_failure_at,
+				expires_at = GREATEST(EXCLUDED.expires_at, COALESCE(login_attempts.locked_until, EXCLUDED.expires_at))
+			RETURNING failures`,
+		key, now, now.Add(window), now.Add(-window),
+	).Scan(&failures).Error
+	return failures, err
+}
+
+func (store *gormAttemptStore) Lock(key string, until time.Time) error {
+	return store.db.Model(&models.LoginAttempt{}).
+		Where("key = ?", key).
+		Updates(map[string]interface{}{
+			"locked_until": until,
+			"expires_at":   gorm.Expr("GREATEST(expires_at, ?)", until),
+		}).Error
+}
+
+func (store *gormAttemptStore) LockedUntil(key string) (time.Time, error) {
+	var attempts []models.LoginAttempt
+	if err := store.db.Where("key = ?", key).Limit(1).Find(&attempts).Error; err != nil {
+		return time.Time{}, err
+	}
+	if len(attempts) == 0 || attempts[0].LockedUntil == nil {
+		return time.Time{}, nil
+	}
+	return *attempts[0].LockedUntil, nil
+}
+
+func (store *gormAttemptStore) Reset(key string) error {
+	return store.db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func (store *gormAttemptStore) PruneExpired(now time.Time) (int64, error) {
+	result := store.db.Where("expires_at < ?", now).Delete(&models.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
@@ -3,6 +3,8 @@ package repository
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 
 	"github.com/qsheker/ToDo-app/internal/models"
 	"gorm.io/driver/postgres"
@@ -24,7 +26,8 @@ func NewDB(cfg Config) (*gorm.DB, error) {
 		cfg.Host, cfg.Username, cfg.Password, cfg.DBName, cfg.Port, cfg.SSLMode,
 	)
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError turns unique violations into gorm.ErrDuplicatedKey.
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +37,84 @@ func NewDB(cfg Config) (*gorm.DB, error) {
 }
 
 func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	// Accounts that existed before email verification keep full access.
+	grandfatherUnverified := db.Migrator().HasTable(&models.User{}) &&
+		!db.Migrator().HasColumn(&models.User{}, "EmailVerified")
+
+	if err := db.AutoMigrate(
 		&models.User{},
-		&models.Todo{})
+		&models.Todo{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserTokenRevocation{},
+		&models.RevokedSession{},
+		&models.Session{},
+		&models.PersonalAccessToken{},
+		&models.PasswordResetToken{},
+		&models.RecoveryCode{},
+		&models.LoginAttempt{},
+		&models.ExternalIdentity{},
+		&models.PasswordHistory{},
+		&models.UsernameAlias{},
+		&models.Reminder{},
+		&models.NotificationDelivery{},
+		&models.Tag{}); err != nil {
+		return err
+	}
+	if err := migrateUsernameKeys(db); err != nil {
+		return err
+	}
+
+	if grandfatherUnverified {
+		if err := db.Model(&models.User{}).Where("1 = 1").Update("email_verified", true).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const usernameKeyIndex = "idx_users_username_key"
+
+// migrateUsernameKeys fills username_key for users created before usernames
+// were case-insensitive and then adds the unique index. Usernames that only
+// differ in case or Unicode form would break the index, so while there are
+// any they are logged and the index is left out; the next start tries again
+// once an admin has renamed all but one of each group.
+func migrateUsernameKeys(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&models.User{}, usernameKeyIndex) {
+		return nil
+	}
+
+	var users []models.User
+	if err := db.Unscoped().Select("id", "username", "username_key").Find(&users).Error; err != nil {
+		return err
+	}
+
+	byKey := make(map[string][]string)
+	for _, user := range users {
+		key := models.NormalizeUsername(user.Username)
+		byKey[key] = append(byKey[key], user.Username)
+		if user.UsernameKey == key {
+			continue
+		}
+		if err := db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).UpdateColumn("username_key", key).Error; err != nil {
+			return err
+		}
+	}
+
+	var collisions []string
+	for _, usernames := range byKey {
+		if len(usernames) > 1 {
+			collisions = append(collisions, strings.Join(usernames, " = "))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		log.Printf("WARNING: usernames collide when compared case-insensitively, rename all but one of each group: %s",
+			strings.Join(collisions, "; "))
+		return nil
+	}
+
+	log.Println("Adding case-insensitive unique index on usernames")
+	return db.Exec("CREATE UNIQUE INDEX " + usernameKeyIndex + " ON users (username_key)").Error
 }
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type gormDeliveryStore struct {
+	db *gorm.DB
+}
+
+// NewDeliveryStore keeps notification idempotency keys in Postgres so that
+// every instance sees what the others delivered.
+func NewDeliveryStore(db *gorm.DB) DeliveryStore {
+	return &gormDeliveryStore{db: db}
+}
+
+// Claim reserves key for the caller until now+lease. It fails if the key
+// was delivered or another sender holds an unexpired claim on it. Keys are
+// kept until now+retention.
+func (store *gormDeliveryStore) Claim(key string, now time.Time, lease, retention time.Duration) (bool, error) {
+	var claimed []string
+	err := store.db.Raw(
+		`INSERT INTO notification_deliveries (key, claimed_until, expires_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET claimed_until = EXCLUDED.claimed_until
+				WHERE notification_deliveries.delivered_at IS NULL
+					AND (notification_deliveries.claimed_until IS NULL OR notification_deliveries.claimed_until < ?)
+			RETURNING key`,
+		key, now.Add(lease), now.Add(retention), now,
+	).Scan(&claimed).Error
+	return len(claimed) == 1, err
+}
+
+func (store *gormDeliveryStore) IsDelivered(key string) (bool, error) {
+	var count int64
+	err := store.db.Model(&models.NotificationDelivery{}).
+		Where("key = ? AND delivered_at IS NOT NULL", key).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (store *gormDeliveryStore) MarkDelivered(key string, now time.Time) error {
+	return store.db.Model(&models.NotificationDelivery{}).
+		Where("key = ?", key).
+		Updates(map[string]interface{}{"delivered_at": now, "claimed_until": nil}).Error
+}
+
+// Release gives up a claim on a key that could not be delivered.
+func (store *gormDeliveryStore) Release(key string) error {
+	return store.db.Model(&models.NotificationDelivery{}).
+		Where("key = ? AND delivered_at IS NULL", key).
+		Update("claimed_until", nil).Error
+}
+
+func (store *gormDeliveryStore) PruneExpired(now time.Time) (int64, error) {
+	result := store.db.Where("expires_at < ?", now).Delete(&models.NotificationDelivery{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type gormExternalIdentityRepo struct {
+	db *gorm.DB
+}
+
+func NewExternalIdentityRepository(db *gorm.DB) ExternalIdentityRepository {
+	return &gormExternalIdentityRepo{db: db}
+}
+
+func (repo *gormExternalIdentityRepo) Create(identity *models.ExternalIdentity) error {
+	return repo.db.Create(identity).Error
+}
+
+func (repo *gormExternalIdentityRepo) GetBySubject(issuer, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	err := repo.db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	return &identity, err
+}
+
+func (repo *gormExternalIdentityRepo) ListByUser(userID uuid.UUID) ([]models.ExternalIdentity, error) {
+	var identities []models.ExternalIdentity
+	err := repo.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
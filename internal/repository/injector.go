@@ -27,6 +27,9 @@ func Injector() *gorm.DB {
 	if err := AutoMigrate(db); err != nil {
 		log.Fatal("Migration failed:", err)
 	}
+	if err := MigrateTodoSearch(db, viper.GetString("database.search_language")); err != nil {
+		log.Fatal("Migration failed:", err)
+	}
 	return db
 }
 func initConfig() error {
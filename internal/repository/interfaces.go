@@ -1,25 +1,146 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/qsheker/ToDo-app/internal/models"
 )
 
 type TodoRepository interface {
 	Create(todo *models.Todo) error
-	GetByID(id int64) (*models.Todo, error)
-	GetAll() ([]models.Todo, error)
+	GetByID(userID uuid.UUID, id int64) (*models.Todo, error)
 	GetByUserID(userID uuid.UUID) ([]models.Todo, error)
+	ListByUser(userID uuid.UUID, query models.TodoListQuery) ([]models.Todo, error)
+	// Search returns the user's todos matching query, best match first.
+	// It returns ErrEmptySearch if the query has nothing to search for.
+	Search(userID uuid.UUID, query models.TodoSearchQuery) ([]models.TodoSearchHit, error)
 	Update(todo *models.Todo) error
-	Delete(id int64) error
-	ToggleComplete(id int64) error
+	Delete(userID uuid.UUID, id int64) error
+	ToggleComplete(userID uuid.UUID, id int64) error
+	LastRank(userID uuid.UUID) (string, error)
+	RankAfter(userID uuid.UUID, rank string) (string, error)
+	RankBefore(userID uuid.UUID, rank string) (string, error)
+	SetRank(userID uuid.UUID, id int64, rank string) error
+	Rebalance(userID uuid.UUID) error
+	ListUsersToRebalance(maxRankLength, limit int) ([]uuid.UUID, error)
+}
+
+type TagRepository interface {
+	Create(tag *models.Tag) error
+	GetByID(userID uuid.UUID, id int64) (*models.Tag, error)
+	Update(tag *models.Tag) error
+	Delete(userID uuid.UUID, id int64) error
+	ListByUser(userID uuid.UUID) ([]models.Tag, error)
+	ListWithCounts(userID uuid.UUID) ([]models.TagSummary, error)
+	Attach(todoID, tagID int64) error
+	Detach(todoID, tagID int64) error
+}
+
+type ReminderRepository interface {
+	Create(reminder *models.Reminder) error
+	ListByTodo(userID uuid.UUID, todoID int64) ([]models.Reminder, error)
+	ListByUser(userID uuid.UUID) ([]models.Reminder, error)
+	Delete(userID uuid.UUID, todoID, id int64) error
+	Reschedule(todoID int64, dueAt *time.Time, now time.Time) error
+	ClaimDue(now time.Time, lease time.Duration, limit, maxAttempts int) ([]models.Reminder, error)
+	MarkSent(id int64, fireAt, now time.Time) error
+	MarkFailed(id int64, fireAt, now time.Time) error
+	ReleaseClaim(id int64) error
+}
+
+type DeliveryStore interface {
+	Claim(key string, now time.Time, lease, retention time.Duration) (bool, error)
+	IsDelivered(key string) (bool, error)
+	MarkDelivered(key string, now time.Time) error
+	Release(key string) error
+	PruneExpired(now time.Time) (int64, error)
 }
 
 type UserRepository interface {
 	Create(user *models.User) error
 	GetByID(id uuid.UUID) (*models.User, error)
 	GetByUsername(username string) (*models.User, error)
+	GetUsernameAlias(username string) (*models.UsernameAlias, error)
+	ChangeUsername(id uuid.UUID, username string, alias *models.UsernameAlias) error
+	GetByEmail(email string) (*models.User, error)
+	ListByVerifiedEmail(email string) ([]models.User, error)
 	Update(user *models.User) error
 	Delete(id uuid.UUID) error
-	GetUser(username, password string) (models.User, error)
+	CountByRole(role string) (int64, error)
+	ClaimVerificationSend(id uuid.UUID, now time.Time, cooldown time.Duration) error
+	MarkEmailVerified(id uuid.UUID, email string) error
+	ReplacePasswordHash(id uuid.UUID, oldHash, newHash string) error
+	ScheduleDeletion(id uuid.UUID, dueAt time.Time) error
+	CancelDeletion(id uuid.UUID) error
+	ListDueForPurge(now time.Time, limit int) ([]models.User, error)
+	Purge(id uuid.UUID, now time.Time) error
+	ClaimTOTPStep(id uuid.UUID, step int64) error
+}
+
+type RefreshTokenRepository interface {
+	Create(token *models.RefreshToken) error
+	GetByHash(hash string) (*models.RefreshToken, error)
+	Rotate(old, next *models.RefreshToken) error
+	RevokeFamily(familyID uuid.UUID) error
+	RevokeByUser(userID uuid.UUID) error
+}
+
+type RevocationStore interface {
+	RevokeToken(jti string, expiresAt time.Time) error
+	RevokeUserTokens(userID uuid.UUID, before, expiresAt time.Time) error
+	RevokeSession(sessionID uuid.UUID, expiresAt time.Time) error
+	IsRevoked(jti string, sessionID, userID uuid.UUID, issuedAt time.Time) (bool, error)
+	PruneExpired(now time.Time) (int64, error)
+}
+
+type SessionRepository interface {
+	Create(session *models.Session) error
+	ListActive(userID uuid.UUID, since time.Time) ([]models.Session, error)
+	ListByUser(userID uuid.UUID) ([]models.Session, error)
+	Touch(id uuid.UUID, at time.Time) error
+	Revoke(userID, id uuid.UUID) error
+	RevokeByUser(userID uuid.UUID) error
+}
+
+type PersonalAccessTokenRepository interface {
+	Create(token *models.PersonalAccessToken) error
+	GetByHash(hash string) (*models.PersonalAccessToken, error)
+	ListByUser(userID uuid.UUID) ([]models.PersonalAccessToken, error)
+	Revoke(userID, id uuid.UUID) error
+	RevokeByUser(userID uuid.UUID) error
+	TouchLastUsed(id uuid.UUID, at time.Time) error
+}
+
+type PasswordResetRepository interface {
+	Create(token *models.PasswordResetToken) error
+	GetValid(hash string, now time.Time) (*models.PasswordResetToken, error)
+	Consume(hash string, now time.Time) (*models.PasswordResetToken, error)
+	InvalidateForUser(userID uuid.UUID) error
+}
+
+type ExternalIdentityRepository interface {
+	Create(identity *models.ExternalIdentity) error
+	GetBySubject(issuer, subject string) (*models.ExternalIdentity, error)
+	ListByUser(userID uuid.UUID) ([]models.ExternalIdentity, error)
+}
+
+type PasswordHistoryRepository interface {
+	Add(entry *models.PasswordHistory, keep int) error
+	Recent(userID uuid.UUID, limit int) ([]models.PasswordHistory, error)
+}
+
+type RecoveryCodeRepository interface {
+	Replace(userID uuid.UUID, codes []models.RecoveryCode) error
+	Consume(userID uuid.UUID, hash string) error
+}
+
+// AttemptStore counts failed sign-ins per key. Counters older than the
+// window start over.
+type AttemptStore interface {
+	RecordFailure(key string, now time.Time, window time.Duration) (int, error)
+	Lock(key string, until time.Time) error
+	LockedUntil(key string) (time.Time, error)
+	Reset(key string) error
+	PruneExpired(now time.Time) (int64, error)
 }
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+	expiresAt     time.Time
+}
+
+type memoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*memoryAttempt
+}
+
+// NewMemoryAttemptStore keeps sign-in counters in process memory. Each
+// instance counts on its own, so only use it when running a single instance.
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{attempts: make(map[string]*memoryAttempt)}
+}
+
+func (store *memoryAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	attempt, ok := store.attempts[key]
+	if !ok {
+		attempt = &memoryAttempt{}
+		store.attempts[key] = attempt
+	}
+	if attempt.lastFailureAt.Before(now.Add(-window)) {
+		attempt.failures = 0
+	}
+	attempt.failures++
+	attempt.lastFailureAt = now
+	attempt.expiresAt = now.Add(window)
+	if attempt.lockedUntil.After(attempt.expiresAt) {
+		attempt.expiresAt = attempt.lockedUntil
+	}
+	return attempt.failures, nil
+}
+
+func (store *memoryAttemptStore) Lock(key string, until time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if attempt, ok := store.attempts[key]; ok {
+		attempt.lockedUntil = until
+		if until.After(attempt.expiresAt) {
+			attempt.expiresAt = until
+		}
+	}
+	return nil
+}
+
+func (store *memoryAttemptStore) LockedUntil(key string) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if attempt, ok := store.attempts[key]; ok {
+		return attempt.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (store *memoryAttemptStore) Reset(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.attempts, key)
+	return nil
+}
+
+func (store *memoryAttemptStore) PruneExpired(now time.Time) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var pruned int64
+	for key, attempt := range store.attempts {
+		if attempt.expiresAt.Before(now) {
+			delete(store.attempts, key)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
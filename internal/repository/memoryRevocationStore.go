@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type userRevocation struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+type memoryRevocationStore struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	sessions map[uuid.UUID]time.Time
+	users    map[uuid.UUID]userRevocation
+}
+
+// NewMemoryRevocationStore keeps revocations in process memory. It is only
+// suitable for a single instance since revocations are lost on restart.
+func NewMemoryRevocationStore() RevocationStore {
+	return &memoryRevocationStore{
+		tokens:   make(map[string]time.Time),
+		sessions: make(map[uuid.UUID]time.Time),
+		users:    make(map[uuid.UUID]userRevocation),
+	}
+}
+
+func (store *memoryRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.tokens[jti] = expiresAt
+	return nil
+}
+
+func (store *memoryRevocationStore) RevokeUserTokens(userID uuid.UUID, before, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.users[userID] = userRevocation{before: before, expiresAt: expiresAt}
+	return nil
+}
+
+func (store *memoryRevocationStore) RevokeSession(sessionID uuid.UUID, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.sessions[sessionID] = expiresAt
+	return nil
+}
+
+func (store *memoryRevocationStore) IsRevoked(jti string, sessionID, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	if _, ok := store.tokens[jti]; ok {
+		return true, nil
+	}
+	if _, ok := store.sessions[sessionID]; ok {
+		return true, nil
+	}
+	if revocation, ok := store.users[userID]; ok && revocation.before.After(issuedAt) {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (store *memoryRevocationStore) PruneExpired(now time.Time) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var pruned int64
+	for jti, expiresAt := range store.tokens {
+		if expiresAt.Before(now) {
+			delete(store.tokens, jti)
+			pruned++
+		}
+	}
+	for sessionID, expiresAt := range store.sessions {
+		if expiresAt.Before(now) {
+			delete(store.sessions, sessionID)
+			pruned++
+		}
+	}
+	for userID, revocation := range store.users {
+		if revocation.expiresAt.Before(now) {
+			delete(store.users, userID)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
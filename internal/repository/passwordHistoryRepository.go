@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type gormPasswordHistoryRepo struct {
+	db *gorm.DB
+}
+
+func NewPasswordHistoryRepository(db *gorm.DB) PasswordHistoryRepository {
+	return &gormPasswordHistoryRepo{db: db}
+}
+
+// Add stores entry and drops everything but the user's newest keep entries.
+func (repo *gormPasswordHistoryRepo) Add(entry *models.PasswordHistory, keep int) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		newest := tx.Model(&models.PasswordHistory{}).
+			Select("id").
+			Where("user_id = ?", entry.UserID).
+			Order("created_at DESC").
+			Limit(keep)
+		return tx.Where("user_id = ? AND id NOT IN (?)", entry.UserID, newest).
+			Delete(&models.PasswordHistory{}).Error
+	})
+}
+
+func (repo *gormPasswordHistoryRepo) Recent(userID uuid.UUID, limit int) ([]models.PasswordHistory, error) {
+	var entries []models.PasswordHistory
+	err := repo.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormPasswordResetRepo struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) PasswordResetRepository {
+	return &gormPasswordResetRepo{db: db}
+}
+
+func (repo *gormPasswordResetRepo) Create(token *models.PasswordResetToken) error {
+	return repo.db.Create(token).Error
+}
+
+func (repo *gormPasswordResetRepo) GetValid(hash string, now time.Time) (*models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	err := repo.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).First(&token).Error
+	return &token, err
+}
+
+// Consume marks an unused, unexpired token as used and returns it. Two
+// concurrent calls with the same token cannot both succeed.
+func (repo *gormPasswordResetRepo) Consume(hash string, now time.Time) (*models.PasswordResetToken, error) {
+	var tokens []models.PasswordResetToken
+	result := repo.db.Model(&tokens).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(tokens) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &tokens[0], nil
+}
+
+func (repo *gormPasswordResetRepo) InvalidateForUser(userID uuid.UUID) error {
+	return repo.db.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+// lastUsedResolution limits last_used_at writes to one per token per minute.
+const lastUsedResolution = time.Minute
+
+type gormPersonalAccessTokenRepo struct {
+	db *gorm.DB
+}
+
+func NewPersonalAccessTokenRepository(db *gorm.DB) PersonalAccessTokenRepository {
+	return &gormPersonalAccessTokenRepo{db: db}
+}
+
+func (repo *gormPersonalAccessTokenRepo) Create(token *models.PersonalAccessToken) error {
+	return repo.db.Create(token).Error
+}
+
+func (repo *gormPersonalAccessTokenRepo) GetByHash(hash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	err := repo.db.Where("token_hash = ?", hash).First(&token).Error
+	return &token, err
+}
+
+func (repo *gormPersonalAccessTokenRepo) ListByUser(userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	err := repo.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (repo *gormPersonalAccessTokenRepo) Revoke(userID, id uuid.UUID) error {
+	result := repo.db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (repo *gormPersonalAccessTokenRepo) RevokeByUser(userID uuid.UUID) error {
+	return repo.db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (repo *gormPersonalAccessTokenRepo) TouchLastUsed(id uuid.UUID, at time.Time) error {
+	return repo.db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, at.Add(-lastUsedResolution)).
+		Update("last_used_at", at).Error
+}
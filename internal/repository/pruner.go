@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Pruner is implemented by stores whose rows expire.
+type Pruner interface {
+	PruneExpired(now time.Time) (int64, error)
+}
+
+// StartPruner removes expired rows from store every interval until ctx is done.
+func StartPruner(ctx context.Context, name string, store Pruner, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _, err := store.PruneExpired(now); err != nil {
+					log.Printf("Error pruning %s: %v", name, err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type gormRecoveryCodeRepo struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepository(db *gorm.DB) RecoveryCodeRepository {
+	return &gormRecoveryCodeRepo{db: db}
+}
+
+// Replace drops every existing code of the user and stores codes instead.
+func (repo *gormRecoveryCodeRepo) Replace(userID uuid.UUID, codes []models.RecoveryCode) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (repo *gormRecoveryCodeRepo) Consume(userID uuid.UUID, hash string) error {
+	result := repo.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
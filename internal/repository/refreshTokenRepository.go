@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type gormRefreshTokenRepo struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) RefreshTokenRepository {
+	return &gormRefreshTokenRepo{db: db}
+}
+
+func (repo *gormRefreshTokenRepo) Create(token *models.RefreshToken) error {
+	return repo.db.Create(token).Error
+}
+
+func (repo *gormRefreshTokenRepo) GetByHash(hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := repo.db.Where("token_hash = ?", hash).First(&token).Error
+	return &token, err
+}
+
+// Rotate revokes old and stores next in one transaction. It returns
+// gorm.ErrRecordNotFound if old was already revoked by a concurrent request.
+func (repo *gormRefreshTokenRepo) Rotate(old, next *models.RefreshToken) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", old.ID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": next.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Create(next).Error
+	})
+}
+
+func (repo *gormRefreshTokenRepo) RevokeFamily(familyID uuid.UUID) error {
+	return repo.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (repo *gormRefreshTokenRepo) RevokeByUser(userID uuid.UUID) error {
+	return repo.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormReminderRepo struct {
+	db *gorm.DB
+}
+
+func NewReminderRepository(db *gorm.DB) ReminderRepository {
+	return &gormReminderRepo{db: db}
+}
+
+func (repo *gormReminderRepo) Create(reminder *models.Reminder) error {
+	return repo.db.Create(reminder).Error
+}
+
+func (repo *gormReminderRepo) ListByTodo(userID uuid.UUID, todoID int64) ([]models.Reminder, error) {
+	var reminders []models.Reminder
+	err := repo.db.Where("user_id = ? AND todo_id = ?", userID, todoID).Order("id").Find(&reminders).Error
+	return reminders, err
+}
+
+func (repo *gormReminderRepo) ListByUser(userID uuid.UUID) ([]models.Reminder, error) {
+	var reminders []models.Reminder
+	err := repo.db.Where("user_id = ?", userID).Order("id").Find(&reminders).Error
+	return reminders, err
+}
+
+func (repo *gormReminderRepo) Delete(userID uuid.UUID, todoID, id int64) error {
+	result := repo.db.Where("user_id = ? AND todo_id = ?", userID, todoID).Delete(&models.Reminder{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Reschedule moves the reminders that are relative to the todo's due date
+// to its new one. Those that now lie in the future fire again, even if they
+// already fired for the old due date.
+func (repo *gormReminderRepo) Reschedule(todoID int64, dueAt *time.Time, now time.Time) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		var reminders []models.Reminder
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("todo_id = ? AND minutes_before_due IS NOT NULL", todoID).
+			Find(&reminders).Error
+		if err != nil {
+			return err
+		}
+		for _, reminder := range reminders {
+			fireAt := reminder.FireTime(dueAt)
+			updates := map[string]interface{}{"fire_at": fireAt}
+			if fireAt != nil && fireAt.After(now) {
+				updates["sent_at"] = nil
+				updates["failed_at"] = nil
+				updates["claimed_until"] = nil
+				updates["attempts"] = 0
+			}
+			if err := tx.Model(&models.Reminder{}).Where("id = ?", reminder.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ClaimDue claims up to limit reminders that are due, oldest first, until
+// now+lease and returns them. Reminders of completed or deleted todos are
+// skipped. Claiming counts as an attempt, and reminders that used up
+// maxAttempts without being sent are marked failed, so a reminder whose
+// sender keeps crashing is given up on too.
+//
+// The claim is committed before anything is sent, so no row stays locked
+// while a notifier talks to the network. Other servers skip claimed
+// reminders until the lease runs out.
+func (repo *gormReminderRepo) ClaimDue(now time.Time, lease time.Duration, limit, maxAttempts int) ([]models.Reminder, error) {
+	var reminders []models.Reminder
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Reminder{}).
+			Where("sent_at IS NULL AND failed_at IS NULL AND attempts >= ?", maxAttempts).
+			Where("claimed_until IS NULL OR claimed_until < ?", now).
+			Updates(map[string]interface{}{"failed_at": now, "claimed_until": nil}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Joins("JOIN todos ON todos.id = reminders.todo_id AND todos.deleted_at IS NULL AND NOT todos.completed").
+			Where("reminders.sent_at IS NULL AND reminders.failed_at IS NULL").
+			Where("reminders.fire_at <= ? AND reminders.attempts < ?", now, maxAttempts).
+			Where("reminders.claimed_until IS NULL OR reminders.claimed_until < ?", now).
+			Order("reminders.fire_at").
+			Limit(limit).
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "reminders"}, Options: "SKIP LOCKED"}).
+			Find(&reminders).Error
+		if err != nil || len(reminders) == 0 {
+			return err
+		}
+
+		claimedUntil := now.Add(lease)
+		ids := make([]int64, len(reminders))
+		for i := range reminders {
+			ids[i] = reminders[i].ID
+			reminders[i].ClaimedUntil = &claimedUntil
+			reminders[i].Attempts++
+		}
+		return tx.Model(&models.Reminder{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"claimed_until": claimedUntil,
+			"attempts":      gorm.Expr("attempts + 1"),
+		}).Error
+	})
+	return reminders, err
+}
+
+// MarkSent records that the reminder went out for fireAt. It does nothing
+// if the reminder was rescheduled in the meantime, so the new time still fires.
+func (repo *gormReminderRepo) MarkSent(id int64, fireAt, now time.Time) error {
+	return repo.db.Model(&models.Reminder{}).
+		Where("id = ? AND fire_at = ?", id, fireAt).
+		Updates(map[string]interface{}{"sent_at": now, "failed_at": nil, "claimed_until": nil}).Error
+}
+
+// MarkFailed records that the reminder could not be sent for fireAt and
+// will not be retried.
+func (repo *gormReminderRepo) MarkFailed(id int64, fireAt, now time.Time) error {
+	return repo.db.Model(&models.Reminder{}).
+		Where("id = ? AND fire_at = ? AND sent_at IS NULL", id, fireAt).
+		Updates(map[string]interface{}{"failed_at": now, "claimed_until": nil}).Error
+}
+
+// ReleaseClaim lets the next run retry a reminder that could not be sent.
+func (repo *gormReminderRepo) ReleaseClaim(id int64) error {
+	return repo.db.Model(&models.Reminder{}).Where("id = ?", id).Update("claimed_until", nil).Error
+}
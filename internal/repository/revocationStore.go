@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormRevocationStore struct {
+	db *gorm.DB
+}
+
+func NewRevocationStore(db *gorm.DB) RevocationStore {
+	return &gormRevocationStore{db: db}
+}
+
+func (store *gormRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	return store.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (store *gormRevocationStore) RevokeUserTokens(userID uuid.UUID, before, expiresAt time.Time) error {
+	return store.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before", "expires_at"}),
+	}).Create(&models.UserTokenRevocation{UserID: userID, RevokedBefore: before, ExpiresAt: expiresAt}).Error
+}
+
+func (store *gormRevocationStore) RevokeSession(sessionID uuid.UUID, expiresAt time.Time) error {
+	return store.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RevokedSession{SessionID: sessionID, ExpiresAt: expiresAt}).Error
+}
+
+func (store *gormRevocationStore) IsRevoked(jti string, sessionID, userID uuid.UUID, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := store.db.Raw(
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+			OR EXISTS (SELECT 1 FROM revoked_sessions WHERE session_id = ?)
+			OR EXISTS (SELECT 1 FROM user_token_revocations WHERE user_id = ? AND revoked_before > ?)`,
+		jti, sessionID, userID, issuedAt,
+	).Scan(&revoked).Error
+	return revoked, err
+}
+
+func (store *gormRevocationStore) PruneExpired(now time.Time) (int64, error) {
+	tokens := store.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{})
+	if tokens.Error != nil {
+		return 0, tokens.Error
+	}
+	sessions := store.db.Where("expires_at < ?", now).Delete(&models.RevokedSession{})
+	if sessions.Error != nil {
+		return tokens.RowsAffected, sessions.Error
+	}
+	users := store.db.Where("expires_at < ?", now).Delete(&models.UserTokenRevocation{})
+	if users.Error != nil {
+		return tokens.RowsAffected + sessions.RowsAffected, users.Error
+	}
+	return tokens.RowsAffected + sessions.RowsAffected + users.RowsAffected, nil
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+type gormSessionRepo struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &gormSessionRepo{db: db}
+}
+
+func (repo *gormSessionRepo) Create(session *models.Session) error {
+	return repo.db.Create(session).Error
+}
+
+// ListActive returns the user's sessions that are not revoked and were seen after since.
+func (repo *gormSessionRepo) ListActive(userID uuid.UUID, since time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := repo.db.
+		Where("user_id = ? AND revoked_at IS NULL AND last_seen_at > ?", userID, since).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (repo *gormSessionRepo) ListByUser(userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := repo.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// Touch updates the last-seen time. It returns gorm.ErrRecordNotFound if
+// the session does not exist.
+func (repo *gormSessionRepo) Touch(id uuid.UUID, at time.Time) error {
+	result := repo.db.Model(&models.Session{}).
+		Where("id = ?", id).
+		Update("last_seen_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Revoke returns gorm.ErrRecordNotFound unless an active session with id
+// belongs to the user.
+func (repo *gormSessionRepo) Revoke(userID, id uuid.UUID) error {
+	result := repo.db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (repo *gormSessionRepo) RevokeByUser(userID uuid.UUID) error {
+	return repo.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormTagRepo struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) TagRepository {
+	return &gormTagRepo{db: db}
+}
+
+func (repo *gormTagRepo) Create(tag *models.Tag) error {
+	return repo.db.Create(tag).Error
+}
+
+func (repo *gormTagRepo) GetByID(userID uuid.UUID, id int64) (*models.Tag, error) {
+	var tag models.Tag
+	err := repo.db.Where("user_id = ?", userID).First(&tag, id).Error
+	return &tag, err
+}
+
+func (repo *gormTagRepo) Update(tag *models.Tag) error {
+	return repo.db.Save(tag).Error
+}
+
+// Delete removes the tag from all of its todos and then the tag itself.
+func (repo *gormTagRepo) Delete(userID uuid.UUID, id int64) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		var tag models.Tag
+		if err := tx.Where("user_id = ?", userID).First(&tag, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM todo_tags WHERE tag_id = ?", tag.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tag).Error
+	})
+}
+
+func (repo *gormTagRepo) ListByUser(userID uuid.UUID) ([]models.Tag, error) {
+	var tags []models.Tag
+	err := repo.db.Where("user_id = ?", userID).Order("name").Find(&tags).Error
+	return tags, err
+}
+
+// ListWithCounts returns the user's tags by name, each with how many of the
+// user's todos carry it in total and how many of those are still open.
+func (repo *gormTagRepo) ListWithCounts(userID uuid.UUID) ([]models.TagSummary, error) {
+	var summaries []models.TagSummary
+	err := repo.db.Raw(`SELECT tags.*,
+			COUNT(todos.id) AS todo_count,
+			COUNT(todos.id) FILTER (WHERE NOT todos.completed) AS open_count
+		FROM tags
+		LEFT JOIN todo_tags ON todo_tags.tag_id = tags.id
+		LEFT JOIN todos ON todos.id = todo_tags.todo_id AND todos.deleted_at IS NULL
+		WHERE tags.user_id = ?
+		GROUP BY tags.id
+		ORDER BY tags.name`, userID).Scan(&summaries).Error
+	return summaries, err
+}
+
+// Attach puts the tag on the todo. Doing it twice is not an error.
+func (repo *gormTagRepo) Attach(todoID, tagID int64) error {
+	return repo.db.Table("todo_tags").
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(map[string]interface{}{"todo_id": todoID, "tag_id": tagID}).Error
+}
+
+// Detach takes the tag off the todo, if it is on it.
+func (repo *gormTagRepo) Detach(todoID, tagID int64) error {
+	return repo.db.Exec("DELETE FROM todo_tags WHERE todo_id = ? AND tag_id = ?", todoID, tagID).Error
+}
@@ -1,49 +1,250 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/rank"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type gormTodoRepo struct {
 	db *gorm.DB
+	// searchLanguage is the text search configuration, see MigrateTodoSearch.
+	searchLanguage string
 }
 
-func NewTodoRepository(db *gorm.DB) TodoRepository {
-	return &gormTodoRepo{db: db}
+func NewTodoRepository(db *gorm.DB, searchLanguage string) TodoRepository {
+	return &gormTodoRepo{db: db, searchLanguage: searchConfig(searchLanguage)}
 }
 
 func (repo *gormTodoRepo) Create(todo *models.Todo) error {
 	return repo.db.Create(todo).Error
 }
 
-func (repo *gormTodoRepo) GetByID(id int64) (*models.Todo, error) {
+func (repo *gormTodoRepo) GetByID(userID uuid.UUID, id int64) (*models.Todo, error) {
 	var todo models.Todo
-	err := repo.db.Preload("User").First(&todo, id).Error
+	err := repo.db.Preload("Tags", orderTags).Where("user_id = ?", userID).First(&todo, id).Error
 	return &todo, err
 }
 
-func (repo *gormTodoRepo) GetAll() ([]models.Todo, error) {
+func (repo *gormTodoRepo) GetByUserID(userID uuid.UUID) ([]models.Todo, error) {
 	var todos []models.Todo
-	err := repo.db.Preload("User").Order("created_at DESC").Find(&todos).Error
+	err := repo.db.Preload("Tags", orderTags).Where("user_id = ?", userID).Order("created_at DESC").Find(&todos).Error
 	return todos, err
 }
 
-func (repo *gormTodoRepo) GetByUserID(userID uuid.UUID) ([]models.Todo, error) {
+func orderTags(db *gorm.DB) *gorm.DB {
+	return db.Order("name")
+}
+
+// ListByUser returns up to query.Limit todos next to query.Cursor in the
+// order of query.Sort. Pages are found by comparing the sort fields with the
+// cursor's values rather than by offset, which keeps them stable while todos
+// are added.
+func (repo *gormTodoRepo) ListByUser(userID uuid.UUID, query models.TodoListQuery) ([]models.Todo, error) {
+	for _, field := range query.Sort {
+		// Field names end up in the SQL, so only whitelisted ones may pass.
+		if !slices.Contains(models.TodoSortFields, field.Field) {
+			return nil, fmt.Errorf("cannot sort todos by %q", field.Field)
+		}
+	}
+
+	db := filterTodos(repo.db.Preload("Tags", orderTags).Where("user_id = ?", userID), query.Filter).Limit(query.Limit)
+
+	backward := query.Cursor != nil && query.Cursor.Backward
+	if query.Cursor != nil {
+		if len(query.Cursor.Values) != len(query.Sort) {
+			return nil, errors.New("cursor does not match the sort order")
+		}
+		condition, args := keysetCondition(query.Sort, query.Cursor.Values, backward)
+		db = db.Where(condition, args...)
+	}
+	for _, field := range query.Sort {
+		// Walking backward reads the rows before the cursor in reverse.
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: field.Field}, Desc: field.Desc != backward})
+	}
+
 	var todos []models.Todo
-	err := repo.db.Where("user_id = ?", userID).Preload("User").Order("created_at DESC").Find(&todos).Error
-	return todos, err
+	if err := db.Find(&todos).Error; err != nil {
+		return nil, err
+	}
+	if backward {
+		slices.Reverse(todos)
+	}
+	return todos, nil
+}
+
+func filterTodos(db *gorm.DB, filter models.TodoFilter) *gorm.DB {
+	if filter.Completed != nil {
+		db = db.Where("completed = ?", *filter.Completed)
+	}
+	if filter.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *filter.CreatedBefore)
+	}
+	if filter.UpdatedAfter != nil {
+		db = db.Where("updated_at >= ?", *filter.UpdatedAfter)
+	}
+	if filter.UpdatedBefore != nil {
+		db = db.Where("updated_at < ?", *filter.UpdatedBefore)
+	}
+	if filter.Overdue != nil {
+		if *filter.Overdue {
+			db = db.Where("due_at < ? AND NOT completed", filter.Now)
+		} else {
+			db = db.Where("(due_at IS NULL OR due_at >= ? OR completed)", filter.Now)
+		}
+	}
+	if len(filter.TagsAny) > 0 {
+		db = db.Where(`id IN (SELECT todo_tags.todo_id FROM todo_tags
+			JOIN tags ON tags.id = todo_tags.tag_id WHERE tags.name IN ?)`, filter.TagsAny)
+	}
+	if len(filter.TagsAll) > 0 {
+		db = db.Where(`id IN (SELECT todo_tags.todo_id FROM todo_tags
+			JOIN tags ON tags.id = todo_tags.tag_id WHERE tags.name IN ?
+			GROUP BY todo_tags.todo_id HAVING COUNT(*) = ?)`, filter.TagsAll, len(filter.TagsAll))
+	}
+	if filter.TitleContains != "" {
+		db = db.Where(`title ILIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(filter.TitleContains)+"%")
+	}
+	return db
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// keysetCondition matches the rows after values in the given order, or the
+// rows before them if backward: (a > x) OR (a = x AND b > y) OR ..., with
+// each comparison flipped for descending fields.
+func keysetCondition(sort []models.SortField, values []interface{}, backward bool) (string, []interface{}) {
+	var alternatives []string
+	var args []interface{}
+	for i, field := range sort {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, sort[j].Field+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if field.Desc != backward {
+			op = "<"
+		}
+		parts = append(parts, field.Field+" "+op+" ?")
+		args = append(args, values[i])
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
 }
 
 func (repo *gormTodoRepo) Update(todo *models.Todo) error {
-	return repo.db.Save(todo).Error
+	// Tags are attached and detached on their own, see TagRepository.
+	return repo.db.Omit(clause.Associations).Save(todo).Error
+}
+
+func (repo *gormTodoRepo) Delete(userID uuid.UUID, id int64) error {
+	result := repo.db.Where("user_id = ?", userID).Delete(&models.Todo{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (repo *gormTodoRepo) ToggleComplete(userID uuid.UUID, id int64) error {
+	result := repo.db.Exec("UPDATE todos SET completed = NOT completed, updated_at = NOW() WHERE id = ? AND user_id = ? AND deleted_at IS NULL", id, userID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// LastRank returns the highest rank among the user's todos, "" if none.
+func (repo *gormTodoRepo) LastRank(userID uuid.UUID) (string, error) {
+	var ranks []string
+	err := repo.db.Model(&models.Todo{}).Where("user_id = ?", userID).
+		Order("rank DESC").Limit(1).Pluck("rank", &ranks).Error
+	if err != nil || len(ranks) == 0 {
+		return "", err
+	}
+	return ranks[0], nil
+}
+
+// RankAfter returns the next higher rank in the user's list, "" if none.
+func (repo *gormTodoRepo) RankAfter(userID uuid.UUID, rank string) (string, error) {
+	var ranks []string
+	err := repo.db.Model(&models.Todo{}).Where("user_id = ? AND rank > ?", userID, rank).
+		Order("rank ASC").Limit(1).Pluck("rank", &ranks).Error
+	if err != nil || len(ranks) == 0 {
+		return "", err
+	}
+	return ranks[0], nil
+}
+
+// RankBefore returns the next lower rank in the user's list, "" if none.
+func (repo *gormTodoRepo) RankBefore(userID uuid.UUID, rank string) (string, error) {
+	var ranks []string
+	err := repo.db.Model(&models.Todo{}).Where("user_id = ? AND rank < ?", userID, rank).
+		Order("rank DESC").Limit(1).Pluck("rank", &ranks).Error
+	if err != nil || len(ranks) == 0 {
+		return "", err
+	}
+	return ranks[0], nil
+}
+
+// SetRank moves one todo and touches no other row.
+func (repo *gormTodoRepo) SetRank(userID uuid.UUID, id int64, rank string) error {
+	result := repo.db.Model(&models.Todo{}).Where("id = ? AND user_id = ?", id, userID).
+		Updates(map[string]interface{}{"rank": rank, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
-func (repo *gormTodoRepo) Delete(id int64) error {
-	return repo.db.Delete(&models.Todo{}, id).Error
+// Rebalance gives all of the user's todos new, short, evenly spaced ranks in
+// their current order. Todos without a rank yet go last, oldest first.
+func (repo *gormTodoRepo) Rebalance(userID uuid.UUID) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		var todos []models.Todo
+		err := tx.Select("id").Where("user_id = ?", userID).
+			Order("rank = '' ASC, rank ASC, created_at ASC, id ASC").
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Find(&todos).Error
+		if err != nil {
+			return err
+		}
+		for i, key := range rank.Spread(len(todos)) {
+			// updated_at stays, the todo itself has not changed.
+			if err := tx.Model(&models.Todo{}).Where("id = ?", todos[i].ID).UpdateColumn("rank", key).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (repo *gormTodoRepo) ToggleComplete(id int64) error {
-	return repo.db.Exec("UPDATE todos SET completed = NOT completed WHERE id = ?", id).Error
+// ListUsersToRebalance finds users with ranks longer than maxRankLength or
+// with todos that have no rank yet.
+func (repo *gormTodoRepo) ListUsersToRebalance(maxRankLength, limit int) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := repo.db.Model(&models.Todo{}).
+		Where("rank = '' OR length(rank) > ?", maxRankLength).
+		Distinct().Limit(limit).Pluck("user_id", &userIDs).Error
+	return userIDs, err
 }
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrEmptySearch = errors.New("search query has no words to search for")
+
+const defaultSearchLanguage = "english"
+
+var (
+	titleHeadlineOptions       = `HighlightAll=true, StartSel="` + models.HighlightStart + `", StopSel="` + models.HighlightStop + `"`
+	descriptionHeadlineOptions = `MaxFragments=2, MaxWords=20, MinWords=5, StartSel="` + models.HighlightStart + `", StopSel="` + models.HighlightStop + `"`
+)
+
+var (
+	searchLanguagePattern = regexp.MustCompile(`^[a-z_]+$`)
+	prefixTerm            = regexp.MustCompile(`^(-?)([\pL\pN_]+)\*$`)
+)
+
+func searchConfig(language string) string {
+	if language == "" {
+		return defaultSearchLanguage
+	}
+	return language
+}
+
+// MigrateTodoSearch adds the search_vector column that Search uses: title
+// and description as a tsvector, with title matches weighted higher. It is a
+// generated column, so Postgres keeps it current on every write. Changing
+// the language rebuilds the column and its GIN index.
+func MigrateTodoSearch(db *gorm.DB, language string) error {
+	language = searchConfig(language)
+	// The language ends up in the DDL, so it must be a known configuration.
+	var known int64
+	if searchLanguagePattern.MatchString(language) {
+		if err := db.Raw("SELECT COUNT(*) FROM pg_ts_config WHERE cfgname = ?", language).Scan(&known).Error; err != nil {
+			return err
+		}
+	}
+	if known == 0 {
+		return fmt.Errorf("unknown text search language %q", language)
+	}
+
+	var expression string
+	err := db.Raw(`SELECT COALESCE(generation_expression, '') FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'todos' AND column_name = 'search_vector'`).
+		Scan(&expression).Error
+	if err != nil {
+		return err
+	}
+	if strings.Contains(expression, "'"+language+"'::regconfig") {
+		return nil
+	}
+
+	log.Printf("Building the todo search index for %s", language)
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"ALTER TABLE todos DROP COLUMN IF EXISTS search_vector",
+			fmt.Sprintf(`ALTER TABLE todos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('%[1]s'::regconfig, COALESCE(title, '')), 'A') ||
+				setweight(to_tsvector('%[1]s'::regconfig, COALESCE(description, '')), 'B')) STORED`, language),
+			"CREATE INDEX idx_todos_search_vector ON todos USING GIN (search_vector)",
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Search ranks the user's todos against the query with ts_rank_cd and
+// highlights the matches. websearch_to_tsquery handles phrases, "or" and
+// exclusions; words ending in * are added as prefix matches, since that
+// syntax has none. Both stem words the same way the column does.
+func (repo *gormTodoRepo) Search(userID uuid.UUID, query models.TodoSearchQuery) ([]models.TodoSearchHit, error) {
+	var parts []string
+	var args []interface{}
+
+	prefixes, rest := splitPrefixTerms(query.Text)
+	for _, prefix := range prefixes {
+		parts = append(parts, "to_tsquery(?::regconfig, ?)")
+		args = append(args, repo.searchLanguage, prefix)
+	}
+	if strings.TrimSpace(rest) != "" {
+		parts = append(parts, "websearch_to_tsquery(?::regconfig, ?)")
+		args = append(args, repo.searchLanguage, rest)
+	}
+	if len(parts) == 0 {
+		return nil, ErrEmptySearch
+	}
+	tsquery := strings.Join(parts, " && ")
+
+	// Stop words alone give an empty tsquery, which matches nothing.
+	var empty bool
+	if err := repo.db.Raw("SELECT numnode("+tsquery+") = 0", args...).Scan(&empty).Error; err != nil {
+		return nil, err
+	}
+	if empty {
+		return nil, ErrEmptySearch
+	}
+
+	// ts_headline is slow, so it only runs on the rows of the page.
+	sql := `SELECT hits.*,
+			ts_headline(?::regconfig, hits.title, hits.query, ?) AS title_highlight,
+			ts_headline(?::regconfig, hits.description, hits.query, ?) AS description_snippet
+		FROM (
+			SELECT todos.id, todos.title, todos.description, todos.completed, todos.due_at, todos.due_time_zone, todos.priority, todos.created_at, todos.updated_at, todos.user_id,
+				ts_rank_cd(todos.search_vector, q.query) AS rank, q.query
+			FROM todos, (SELECT ` + tsquery + ` AS query) q
+			WHERE todos.user_id = ? AND todos.deleted_at IS NULL AND todos.search_vector @@ q.query
+			ORDER BY rank DESC, todos.id DESC
+			LIMIT ?
+		) hits
+		ORDER BY hits.rank DESC, hits.id DESC`
+
+	queryArgs := append([]interface{}{repo.searchLanguage, titleHeadlineOptions, repo.searchLanguage, descriptionHeadlineOptions}, args...)
+	queryArgs = append(queryArgs, userID, query.Limit)
+
+	var hits []models.TodoSearchHit
+	err := repo.db.Raw(sql, queryArgs...).Scan(&hits).Error
+	return hits, err
+}
+
+// splitPrefixTerms takes the words ending in * that are not inside quotes
+// out of text and returns them in to_tsquery syntax, e.g. "-dep*" becomes
+// "!dep:*". The rest of text is returned as it was.
+func splitPrefixTerms(text string) ([]string, string) {
+	var prefixes, rest []string
+	inQuotes := false
+	for _, word := range strings.Fields(text) {
+		if !inQuotes {
+			if match := prefixTerm.FindStringSubmatch(word); match != nil {
+				operator := ""
+				if match[1] == "-" {
+					operator = "!"
+				}
+				prefixes = append(prefixes, operator+match[2]+":*")
+				continue
+			}
+		}
+		if strings.Count(word, `"`)%2 == 1 {
+			inQuotes = !inQuotes
+		}
+		rest = append(rest, word)
+	}
+	return prefixes, strings.Join(rest, " ")
+}
@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/qsheker/ToDo-app/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type gormUserRepo struct {
@@ -19,23 +22,190 @@ func (repo *gormUserRepo) Create(user *models.User) error {
 }
 func (repo *gormUserRepo) GetByID(id uuid.UUID) (*models.User, error) {
 	var user models.User
-	err := repo.db.Preload("Todos").First(&user, id).Error
+	err := repo.db.First(&user, id).Error
 	return &user, err
 }
 func (repo *gormUserRepo) GetByUsername(username string) (*models.User, error) {
 	var user models.User
-	err := repo.db.Where("username = ?", username).First(&user).Error
+	// The exact spelling wins while migrateUsernameKeys still reports collisions.
+	err := repo.db.Where("username_key = ?", models.NormalizeUsername(username)).
+		Order(clause.Expr{SQL: "username = ? DESC", Vars: []interface{}{username}}).
+		First(&user).Error
+	return &user, err
+}
+
+// GetUsernameAlias finds who used to have the username, if anyone did.
+func (repo *gormUserRepo) GetUsernameAlias(username string) (*models.UsernameAlias, error) {
+	var alias models.UsernameAlias
+	err := repo.db.Where("key = ?", models.NormalizeUsername(username)).First(&alias).Error
+	return &alias, err
+}
+
+// ChangeUsername renames the user and, if alias is not nil, records the old
+// name in the same transaction. An existing alias for that name is replaced.
+func (repo *gormUserRepo) ChangeUsername(id uuid.UUID, username string, alias *models.UsernameAlias) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"username":     username,
+			"username_key": models.NormalizeUsername(username),
+			"updated_at":   time.Now(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if alias == nil {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"username", "user_id", "reserved_until", "created_at"}),
+		}).Create(alias).Error
+	})
+}
+func (repo *gormUserRepo) GetByEmail(email string) (*models.User, error) {
+	var user models.User
+	err := repo.db.Where("LOWER(email) = LOWER(?)", email).First(&user).Error
 	return &user, err
 }
+
+// ListByVerifiedEmail returns every user who verified the email address.
+// Addresses are not unique, so there can be several.
+func (repo *gormUserRepo) ListByVerifiedEmail(email string) ([]models.User, error) {
+	var users []models.User
+	err := repo.db.Where("LOWER(email) = LOWER(?) AND email_verified", email).Order("created_at").Find(&users).Error
+	return users, err
+}
 func (repo *gormUserRepo) Update(user *models.User) error {
-	return repo.db.Save(user).Error
+	return repo.db.Omit(clause.Associations).Save(user).Error
 }
 func (repo *gormUserRepo) Delete(id uuid.UUID) error {
 	return repo.db.Delete(&models.User{}, id).Error
 }
-func (repo *gormUserRepo) GetUser(username, password string) (models.User, error) {
-	var user models.User
-	err := repo.db.Raw("SELECT * FROM users WHERE username = ? AND password = ?", username, password).
-		Scan(&user).Error
-	return user, err
+func (repo *gormUserRepo) CountByRole(role string) (int64, error) {
+	var count int64
+	err := repo.db.Model(&models.User{}).Where("role = ?", role).Count(&count).Error
+	return count, err
+}
+
+// ClaimVerificationSend records that a verification mail is being sent. It
+// returns gorm.ErrRecordNotFound if the previous one was sent within cooldown.
+func (repo *gormUserRepo) ClaimVerificationSend(id uuid.UUID, now time.Time, cooldown time.Duration) error {
+	result := repo.db.Model(&models.User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)", id, now.Add(-cooldown)).
+		Update("verification_sent_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// MarkEmailVerified only succeeds while the account still has the given email.
+func (repo *gormUserRepo) MarkEmailVerified(id uuid.UUID, email string) error {
+	result := repo.db.Model(&models.User{}).
+		Where("id = ? AND email = ?", id, email).
+		Update("email_verified", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ClaimTOTPStep records step as the last accepted TOTP time step. It returns
+// gorm.ErrRecordNotFound if that step or a later one was already used.
+func (repo *gormUserRepo) ClaimTOTPStep(id uuid.UUID, step int64) error {
+	result := repo.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ReplacePasswordHash swaps the hash only if it is still oldHash. It returns
+// gorm.ErrRecordNotFound if the password changed in the meantime.
+func (repo *gormUserRepo) ReplacePasswordHash(id uuid.UUID, oldHash, newHash string) error {
+	result := repo.db.Model(&models.User{}).
+		Where("id = ? AND password = ?", id, oldHash).
+		Update("password", newHash)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (repo *gormUserRepo) ScheduleDeletion(id uuid.UUID, dueAt time.Time) error {
+	return repo.db.Model(&models.User{}).Where("id = ?", id).Update("deletion_due_at", dueAt).Error
+}
+
+func (repo *gormUserRepo) CancelDeletion(id uuid.UUID) error {
+	return repo.db.Model(&models.User{}).Where("id = ?", id).Update("deletion_due_at", nil).Error
+}
+
+// ListDueForPurge returns users whose deletion grace period is over, and
+// users that were soft-deleted before deletions were scheduled.
+func (repo *gormUserRepo) ListDueForPurge(now time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	err := repo.db.Unscoped().
+		Where("deletion_due_at <= ? OR deleted_at IS NOT NULL", now).
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
+
+// Purge permanently deletes the user and every row that belongs to them.
+// The user row is locked and re-checked first, so a deletion cancelled in
+// the meantime wins; in that case gorm.ErrRecordNotFound is returned.
+func (repo *gormUserRepo) Purge(id uuid.UUID, now time.Time) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Unscoped().
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND (deletion_due_at <= ? OR deleted_at IS NOT NULL)", id, now).
+			First(&user).Error
+		if err != nil {
+			return err
+		}
+
+		// todo_tags has no user_id; its rows go with the user's tags.
+		if err := tx.Exec("DELETE FROM todo_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?)", id).Error; err != nil {
+			return err
+		}
+
+		owned := []interface{}{
+			&models.Todo{},
+			&models.RefreshToken{},
+			&models.Session{},
+			&models.PersonalAccessToken{},
+			&models.PasswordResetToken{},
+			&models.RecoveryCode{},
+			&models.ExternalIdentity{},
+			&models.PasswordHistory{},
+			&models.UserTokenRevocation{},
+			&models.UsernameAlias{},
+			&models.Reminder{},
+			&models.Tag{},
+		}
+		for _, model := range owned {
+			if err := tx.Unscoped().Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&models.User{}, "id = ?", id).Error
+	})
 }
@@ -3,37 +3,103 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/qsheker/ToDo-app/internal/handlers"
+	"github.com/qsheker/ToDo-app/internal/models"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func RegisterRoutes(r *gin.Engine, todoHandler *handlers.TodoHandler, userHandler *handlers.UserHandler, authHandler *handlers.AuthHandler) {
+func RegisterRoutes(r *gin.Engine, todoHandler *handlers.TodoHandler, userHandler *handlers.UserHandler, authHandler *handlers.AuthHandler, wellKnownHandler *handlers.WellKnownHandler, tokenHandler *handlers.PersonalAccessTokenHandler, twoFactorHandler *handlers.TwoFactorHandler, oidcHandler *handlers.OIDCHandler, sessionHandler *handlers.SessionHandler, reminderHandler *handlers.ReminderHandler, tagHandler *handlers.TagHandler) {
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	wellKnownRoutes := r.Group("/.well-known")
+	{
+		wellKnownRoutes.GET("/jwks.json", wellKnownHandler.JWKS)
+		wellKnownRoutes.GET("/openid-configuration", wellKnownHandler.OpenIDConfiguration)
+	}
+
 	authRoutes := r.Group("/auth")
 	{
 		authRoutes.POST("/sign-up", authHandler.SignUp)
 		authRoutes.POST("/sign-in", authHandler.SignIn)
+		authRoutes.POST("/sign-in/2fa", authHandler.SignInTwoFactor)
+		authRoutes.POST("/refresh", authHandler.Refresh)
+		authRoutes.POST("/logout", authHandler.UserIdentity, handlers.RequireSession, authHandler.Logout)
+		authRoutes.POST("/logout-all", authHandler.UserIdentity, handlers.RequireSession, authHandler.LogoutAll)
+		authRoutes.POST("/password/forgot", authHandler.ForgotPassword)
+		authRoutes.POST("/password/reset", authHandler.ResetPassword)
+		authRoutes.GET("/verify-email", authHandler.VerifyEmail)
+		authRoutes.POST("/verify-email/resend", authHandler.UserIdentity, handlers.RequireSession, authHandler.ResendVerification)
+
+		// SSO is optional; the handler is nil when oidc is not configured.
+		if oidcHandler != nil {
+			authRoutes.GET("/oidc/login", oidcHandler.Login)
+			authRoutes.GET("/oidc/callback", oidcHandler.Callback)
+		}
+	}
+
+	todosRead := handlers.RequireScope(models.ScopeTodosRead)
+	todosWrite := handlers.RequireScope(models.ScopeTodosWrite)
+	verified := handlers.RequireVerifiedEmail
+	todoRoutes := r.Group("/todos", authHandler.UserIdentity)
+	{
+		todoRoutes.POST("/", todosWrite, verified, todoHandler.CreateTodo)
+		todoRoutes.GET("/", todosRead, todoHandler.GetAllTodo)
+		todoRoutes.GET("/search", todosRead, todoHandler.SearchTodos)
+		todoRoutes.GET("/:id", todosRead, todoHandler.GetTodoByID)
+		todoRoutes.GET("/user/:userID", todosRead, handlers.RequirePermission(models.PermissionTodosReadAll), todoHandler.GetTodosByUserID)
+		todoRoutes.PUT("/:id", todosWrite, verified, todoHandler.UpdateTodo)
+		todoRoutes.DELETE("/:id", todosWrite, verified, todoHandler.DeleteTodo)
+		todoRoutes.PATCH("/:id/toggle", todosWrite, verified, todoHandler.ToggleComplete)
+		todoRoutes.POST("/:id/move", todosWrite, verified, todoHandler.MoveTodo)
+		todoRoutes.GET("/:id/reminders", todosRead, reminderHandler.List)
+		todoRoutes.POST("/:id/reminders", todosWrite, verified, reminderHandler.Create)
+		todoRoutes.DELETE("/:id/reminders/:reminderID", todosWrite, verified, reminderHandler.Delete)
+		todoRoutes.PUT("/:id/tags/:tagID", todosWrite, verified, tagHandler.Attach)
+		todoRoutes.DELETE("/:id/tags/:tagID", todosWrite, verified, tagHandler.Detach)
 	}
 
-	todoRoutes := r.Group("/todos")
+	tagRoutes := r.Group("/tags", authHandler.UserIdentity)
 	{
-		todoRoutes.POST("/", todoHandler.CreateTodo)
-		todoRoutes.GET("/", todoHandler.GetAllTodo)
-		todoRoutes.GET("/:id", todoHandler.GetTodoByID)
-		todoRoutes.GET("/user/:userID", todoHandler.GetTodosByUserID)
-		todoRoutes.PUT("/:id", todoHandler.UpdateTodo)
-		todoRoutes.DELETE("/:id", todoHandler.DeleteTodo)
-		todoRoutes.PATCH("/:id/toggle", todoHandler.ToggleComplete)
+		tagRoutes.GET("/", todosRead, tagHandler.List)
+		tagRoutes.POST("/", todosWrite, verified, tagHandler.Create)
+		tagRoutes.PUT("/:id", todosWrite, verified, tagHandler.Update)
+		tagRoutes.DELETE("/:id", todosWrite, verified, tagHandler.Delete)
 	}
 
-	userRoutes := r.Group("/users")
+	usersRead := handlers.RequireScope(models.ScopeUsersRead)
+	usersWrite := handlers.RequireScope(models.ScopeUsersWrite)
+	manageUsers := handlers.RequirePermission(models.PermissionUsersManage)
+	userRoutes := r.Group("/users", authHandler.UserIdentity)
 	{
-		userRoutes.POST("/", userHandler.CreateUser)
-		userRoutes.GET("/:id", userHandler.GetUserById)
-		userRoutes.GET("/username/:username", userHandler.GetByUsername)
-		userRoutes.PUT("/", userHandler.Update)
-		userRoutes.DELETE("/:id", userHandler.Delete)
+		userRoutes.POST("/", usersWrite, manageUsers, userHandler.CreateUser)
+		userRoutes.GET("/:id", usersRead, userHandler.GetUserById)
+		userRoutes.GET("/username/:username", usersRead, userHandler.GetByUsername)
+		userRoutes.PUT("/", usersWrite, handlers.RequireSession, userHandler.Update)
+		userRoutes.GET("/me/export", handlers.RequireSession, userHandler.Export)
+		userRoutes.PUT("/me/username", handlers.RequireSession, userHandler.ChangeUsername)
+		userRoutes.DELETE("/:id", usersWrite, userHandler.Delete)
+		userRoutes.PUT("/:id/role", usersWrite, manageUsers, handlers.RequireSession, userHandler.UpdateRole)
+		userRoutes.POST("/:id/unlock", usersWrite, manageUsers, handlers.RequireSession, userHandler.Unlock)
+
+		tokenRoutes := userRoutes.Group("/me/tokens", handlers.RequireSession, handlers.RequireVerifiedEmail)
+		{
+			tokenRoutes.POST("/", tokenHandler.Create)
+			tokenRoutes.GET("/", tokenHandler.List)
+			tokenRoutes.DELETE("/:id", tokenHandler.Revoke)
+		}
+
+		sessionRoutes := userRoutes.Group("/me/sessions", handlers.RequireSession)
+		{
+			sessionRoutes.GET("/", sessionHandler.List)
+			sessionRoutes.DELETE("/:id", sessionHandler.Revoke)
+		}
+
+		twoFactorRoutes := userRoutes.Group("/me/2fa", handlers.RequireSession)
+		{
+			twoFactorRoutes.POST("/enroll", twoFactorHandler.Enroll)
+			twoFactorRoutes.POST("/confirm", twoFactorHandler.Confirm)
+			twoFactorRoutes.POST("/disable", twoFactorHandler.Disable)
+		}
 	}
 }
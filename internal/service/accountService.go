@@ -0,0 +1,165 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize limits how many accounts one purge run deletes.
+const purgeBatchSize = 100
+
+type AccountService interface {
+	ScheduleDeletion(userID uuid.UUID) (time.Time, error)
+	Export(userID uuid.UUID, w io.Writer) error
+	PruneExpired(now time.Time) (int64, error)
+}
+
+type AccountServiceImpl struct {
+	userRepo     repository.UserRepository
+	todoRepo     repository.TodoRepository
+	reminderRepo repository.ReminderRepository
+	tagRepo      repository.TagRepository
+	sessionRepo  repository.SessionRepository
+	patRepo      repository.PersonalAccessTokenRepository
+	identityRepo repository.ExternalIdentityRepository
+	jwtService   JwtService
+	gracePeriod  time.Duration
+}
+
+func NewAccountService(userRepo repository.UserRepository, todoRepo repository.TodoRepository, reminderRepo repository.ReminderRepository, tagRepo repository.TagRepository, sessionRepo repository.SessionRepository, patRepo repository.PersonalAccessTokenRepository, identityRepo repository.ExternalIdentityRepository, jwtService JwtService, gracePeriod time.Duration) AccountService {
+	return &AccountServiceImpl{
+		userRepo:     userRepo,
+		todoRepo:     todoRepo,
+		reminderRepo: reminderRepo,
+		tagRepo:      tagRepo,
+		sessionRepo:  sessionRepo,
+		patRepo:      patRepo,
+		identityRepo: identityRepo,
+		jwtService:   jwtService,
+		gracePeriod:  gracePeriod,
+	}
+}
+
+// ScheduleDeletion signs the user out everywhere and schedules the account
+// to be purged once the grace period is over. Signing in again cancels it.
+func (s *AccountServiceImpl) ScheduleDeletion(userID uuid.UUID) (time.Time, error) {
+	if _, err := s.userRepo.GetByID(userID); err != nil {
+		return time.Time{}, notFoundAs(err, ErrUserNotFound)
+	}
+
+	dueAt := time.Now().Add(s.gracePeriod)
+	if err := s.userRepo.ScheduleDeletion(userID, dueAt); err != nil {
+		return time.Time{}, err
+	}
+	if err := s.jwtService.LogoutAll(userID); err != nil {
+		return time.Time{}, err
+	}
+	return dueAt, nil
+}
+
+// Export writes a ZIP archive with one JSON file per kind of data held
+// about the user. Secrets such as password and token hashes are left out.
+func (s *AccountServiceImpl) Export(userID uuid.UUID, w io.Writer) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return notFoundAs(err, ErrUserNotFound)
+	}
+	todos, err := s.todoRepo.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+	reminders, err := s.reminderRepo.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	tags, err := s.tagRepo.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	sessions, err := s.sessionRepo.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	tokens, err := s.patRepo.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	identities, err := s.identityRepo.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+
+	todoResponses := make([]models.TodoResponse, len(todos))
+	for i, todo := range todos {
+		todoResponses[i] = models.TodoResponse{
+			ID:          todo.ID,
+			Title:       todo.Title,
+			Description: todo.Description,
+			Completed:   todo.Completed,
+			DueAt:       localDueAt(todo.DueAt, todo.DueTimeZone),
+			DueTimeZone: todo.DueTimeZone,
+			Priority:    todo.Priority,
+			Tags:        todo.Tags,
+			CreatedAt:   todo.CreatedAt,
+			UpdatedAt:   todo.UpdatedAt,
+			UserID:      todo.UserID,
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"todos.json", todoResponses},
+		{"reminders.json", reminders},
+		{"tags.json", tags},
+		{"sessions.json", sessions},
+		{"personal_access_tokens.json", tokens},
+		{"linked_identities.json", identities},
+	}
+	for _, file := range files {
+		entry, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// PruneExpired permanently deletes accounts whose grace period is over.
+func (s *AccountServiceImpl) PruneExpired(now time.Time) (int64, error) {
+	users, err := s.userRepo.ListDueForPurge(now, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, user := range users {
+		err := s.userRepo.Purge(user.ID, now)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		log.Printf("Purged account %s", user.ID)
+		purged++
+	}
+	return purged, nil
+}
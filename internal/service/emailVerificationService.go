@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/mail"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	emailVerificationTTL      = 24 * time.Hour
+	emailVerificationAudience = "email-verification"
+	emailVerificationType     = "email-verification+jwt"
+
+	// EmailVerificationCooldown is how long callers must wait between resends.
+	EmailVerificationCooldown = 2 * time.Minute
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification link")
+	ErrVerificationRateLimited  = errors.New("verification email was sent recently, try again later")
+	ErrEmailAlreadyVerified     = errors.New("email is already verified")
+	ErrNoEmailAddress           = errors.New("account has no email address")
+)
+
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+type EmailVerificationService interface {
+	Send(userID uuid.UUID) error
+	Verify(token string) error
+}
+
+type EmailVerificationServiceImpl struct {
+	keys      *KeySet
+	userRepo  repository.UserRepository
+	mailer    mail.Mailer
+	verifyURL string
+}
+
+func NewEmailVerificationService(keys *KeySet, userRepo repository.UserRepository, mailer mail.Mailer, verifyURL string) EmailVerificationService {
+	return &EmailVerificationServiceImpl{keys: keys, userRepo: userRepo, mailer: mailer, verifyURL: verifyURL}
+}
+
+// Send mails a signed verification link. The link is bound to the current
+// address, so changing the email invalidates links sent for the old one.
+func (s *EmailVerificationServiceImpl) Send(userID uuid.UUID) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	if user.Email == "" {
+		return ErrNoEmailAddress
+	}
+
+	now := time.Now()
+	if err := s.userRepo.ClaimVerificationSend(user.ID, now, EmailVerificationCooldown); err != nil {
+		return notFoundAs(err, ErrVerificationRateLimited)
+	}
+
+	token, err := s.keys.signInternal(emailVerificationType, emailVerificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(emailVerificationTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email for ToDo",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s?token=%s\n",
+			user.Name, int(emailVerificationTTL.Hours()), s.verifyURL, url.QueryEscape(token),
+		),
+	}
+	go func() {
+		if err := s.mailer.Send(msg); err != nil {
+			log.Println("Error sending verification mail: ", err)
+		}
+	}()
+	return nil
+}
+
+func (s *EmailVerificationServiceImpl) Verify(token string) error {
+	var claims emailVerificationClaims
+	err := s.keys.parseInternal(token, emailVerificationType, &claims,
+		jwt.WithAudience(emailVerificationAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	if err := s.userRepo.MarkEmailVerified(userID, claims.Email); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		return err
+	}
+	return nil
+}
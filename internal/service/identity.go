@@ -0,0 +1,43 @@
+package service
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+)
+
+// Identity is the authenticated caller extracted from a verified token.
+type Identity struct {
+	UserID    uuid.UUID
+	Username  string
+	Role      string
+	TokenID   string
+	SessionID uuid.UUID
+	ExpiresAt time.Time
+
+	EmailVerified bool
+
+	// PersonalTokenID and Scopes are only set for personal access tokens.
+	// Session tokens carry no scopes and may call every route.
+	PersonalTokenID uuid.UUID
+	Scopes          []string
+}
+
+func (i *Identity) IsPersonalToken() bool {
+	return i.PersonalTokenID != uuid.Nil
+}
+
+func (i *Identity) HasScope(scope string) bool {
+	return !i.IsPersonalToken() || slices.Contains(i.Scopes, scope)
+}
+
+func (i *Identity) HasPermission(permission string) bool {
+	return models.RoleHasPermission(i.Role, permission)
+}
+
+// CanManageUser reports whether the caller may modify the given account.
+func (i *Identity) CanManageUser(userID uuid.UUID) bool {
+	return i.UserID == userID || i.HasPermission(models.PermissionUsersManage)
+}
@@ -1,45 +1,395 @@
 package service
 
 import (
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
 	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
 )
 
-const signingKey = "fbce1ceef702296950744b17f161021bc9bcee13bb9063a2b524eef6f3c285dc"
+const (
+	accessTokenTTL  = 2 * time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	// sessionTouchInterval limits how often a session's last-seen time is written.
+	sessionTouchInterval = 5 * time.Minute
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid or expired token")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrSessionNotFound     = errors.New("session not found")
+)
 
 type tokenClaims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	Verified bool   `json:"email_verified"`
+	// SessionID is missing from tokens issued before sessions were tracked.
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type JwtService interface {
-	GenerateToken(username, password string) (string, error)
+	GenerateToken(user *models.User, sessionID uuid.UUID) (string, error)
+	ParseToken(accessToken string) (*Identity, error)
+	IssueTokens(user *models.User, client models.ClientInfo) (*models.TokenResponse, error)
+	Refresh(refreshToken string, client models.ClientInfo) (*models.TokenResponse, error)
+	Logout(identity *Identity, refreshToken string) error
+	LogoutAll(userID uuid.UUID) error
+	Sessions(userID uuid.UUID) ([]models.Session, error)
+	RevokeSession(userID, sessionID uuid.UUID) error
+	JWKS() models.JSONWebKeySet
+	Discovery() models.OpenIDConfiguration
 }
 
 type JwtServiceImpl struct {
-	repo repository.UserRepository
+	keys        *KeySet
+	repo        repository.UserRepository
+	refreshRepo repository.RefreshTokenRepository
+	revocations repository.RevocationStore
+	sessions    repository.SessionRepository
+
+	// lastTouched maps session IDs to when their last-seen time was last written.
+	lastTouched sync.Map
 }
 
-func NewJwtService(repo repository.UserRepository) JwtService {
-	return &JwtServiceImpl{repo: repo}
+func NewJwtService(keys *KeySet, repo repository.UserRepository, refreshRepo repository.RefreshTokenRepository, revocations repository.RevocationStore, sessions repository.SessionRepository) JwtService {
+	return &JwtServiceImpl{keys: keys, repo: repo, refreshRepo: refreshRepo, revocations: revocations, sessions: sessions}
 }
 
-func (s *JwtServiceImpl) GenerateToken(username, password string) (string, error) {
-	user, err := s.repo.GetUser(username, password)
-	if err != nil {
-		return "", err
-	}
+func (s *JwtServiceImpl) GenerateToken(user *models.User, sessionID uuid.UUID) (string, error) {
 	claims := tokenClaims{
-		UserID:   user.ID.String(),
-		Username: user.Username,
+		UserID:    user.ID.String(),
+		Username:  user.Username,
+		Role:      user.Role,
+		Verified:  user.EmailVerified,
+		SessionID: sessionID.String(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(2 * time.Hour)),
+			ID:        uuid.NewString(),
+			Issuer:    s.keys.issuer,
+			Subject:   user.ID.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(signingKey))
+	if s.keys.audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.keys.audience}
+	}
+	return s.keys.sign(claims)
+}
+
+func (s *JwtServiceImpl) ParseToken(accessToken string) (*Identity, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(s.keys.algorithms()),
+		jwt.WithExpirationRequired(),
+	}
+	if s.keys.issuer != "" {
+		options = append(options, jwt.WithIssuer(s.keys.issuer))
+	}
+	if s.keys.audience != "" {
+		options = append(options, jwt.WithAudience(s.keys.audience))
+	}
+
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(accessToken, &claims, s.keys.keyFunc, options...)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil || claims.ID == "" || claims.IssuedAt == nil {
+		return nil, ErrInvalidToken
+	}
+	var sessionID uuid.UUID
+	if claims.SessionID != "" {
+		if sessionID, err = uuid.Parse(claims.SessionID); err != nil {
+			return nil, ErrInvalidToken
+		}
+	}
+
+	revoked, err := s.revocations.IsRevoked(claims.ID, sessionID, userID, claims.IssuedAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+	if sessionID != uuid.Nil {
+		s.touchSession(sessionID)
+	}
+
+	return &Identity{
+		UserID:    userID,
+		Username:  claims.Username,
+		Role:      claims.Role,
+		TokenID:   claims.ID,
+		SessionID: sessionID,
+		ExpiresAt: claims.ExpiresAt.Time,
+
+		EmailVerified: claims.Verified,
+	}, nil
+}
+
+// Logout ends the caller's session. Tokens from before sessions were
+// tracked revoke the access token and, if given, its refresh token family.
+func (s *JwtServiceImpl) Logout(identity *Identity, refreshToken string) error {
+	if identity.SessionID != uuid.Nil {
+		return s.endSession(identity.UserID, identity.SessionID)
+	}
+
+	if err := s.revocations.RevokeToken(identity.TokenID, identity.ExpiresAt); err != nil {
+		return err
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	token, err := s.refreshRepo.GetByHash(hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if token.UserID != identity.UserID {
+		return nil
+	}
+	return s.refreshRepo.RevokeFamily(token.FamilyID)
+}
+
+// LogoutAll revokes every access and refresh token issued to the user so far.
+// Session tokens are revoked through their sessions. The user-wide cutoff
+// covers the rest and is rounded down to the second, because iat is in
+// whole seconds and a token issued right after this call must stay valid.
+func (s *JwtServiceImpl) LogoutAll(userID uuid.UUID) error {
+	now := time.Now()
+	active, err := s.sessions.ListActive(userID, now.Add(-refreshTokenTTL))
+	if err != nil {
+		return err
+	}
+	for _, session := range active {
+		s.lastTouched.Delete(session.ID)
+		if err := s.revocations.RevokeSession(session.ID, now.Add(accessTokenTTL)); err != nil {
+			return err
+		}
+	}
+	if err := s.revocations.RevokeUserTokens(userID, now.Truncate(time.Second), now.Add(accessTokenTTL)); err != nil {
+		return err
+	}
+	if err := s.sessions.RevokeByUser(userID); err != nil {
+		return err
+	}
+	return s.refreshRepo.RevokeByUser(userID)
+}
+
+// Sessions lists the user's sessions that can still be refreshed.
+func (s *JwtServiceImpl) Sessions(userID uuid.UUID) ([]models.Session, error) {
+	return s.sessions.ListActive(userID, time.Now().Add(-refreshTokenTTL))
+}
+
+func (s *JwtServiceImpl) RevokeSession(userID, sessionID uuid.UUID) error {
+	return s.endSession(userID, sessionID)
+}
+
+// endSession revokes the session, its refresh token family and the access
+// tokens issued for it.
+func (s *JwtServiceImpl) endSession(userID, sessionID uuid.UUID) error {
+	if err := s.sessions.Revoke(userID, sessionID); err != nil {
+		return notFoundAs(err, ErrSessionNotFound)
+	}
+	s.lastTouched.Delete(sessionID)
+	if err := s.revocations.RevokeSession(sessionID, time.Now().Add(accessTokenTTL)); err != nil {
+		return err
+	}
+	return s.refreshRepo.RevokeFamily(sessionID)
+}
+
+// IssueTokens starts a new session and with it a new refresh token family.
+// Every kind of sign-in ends here, so it also cancels a pending deletion.
+func (s *JwtServiceImpl) IssueTokens(user *models.User, client models.ClientInfo) (*models.TokenResponse, error) {
+	if user.DeletionDueAt != nil {
+		if err := s.repo.CancelDeletion(user.ID); err != nil {
+			return nil, err
+		}
+		log.Printf("Cancelled scheduled deletion of user %s on sign-in", user.ID)
+		user.DeletionDueAt = nil
+	}
+
+	session := newSession(user.ID, uuid.New(), client)
+	if err := s.sessions.Create(session); err != nil {
+		return nil, err
+	}
+
+	access, err := s.GenerateToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, refresh, err := s.newRefreshToken(user.ID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.refreshRepo.Create(refresh); err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		Token:        access,
+		RefreshToken: raw,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already
+// rotated revokes its whole family, since it means the token leaked.
+func (s *JwtServiceImpl) Refresh(refreshToken string, client models.ClientInfo) (*models.TokenResponse, error) {
+	current, err := s.refreshRepo.GetByHash(hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if current.RevokedAt != nil {
+		return nil, s.revokeReusedFamily(current)
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := s.repo.GetByID(current.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	raw, next, err := s.newRefreshToken(user.ID, current.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.refreshRepo.Rotate(current, next); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, s.revokeReusedFamily(current)
+		}
+		return nil, err
+	}
+
+	// Families started before sessions were tracked get a session on their first refresh.
+	if err := s.sessions.Touch(current.FamilyID, time.Now()); errors.Is(err, gorm.ErrRecordNotFound) {
+		err = s.sessions.Create(newSession(user.ID, current.FamilyID, client))
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	access, err := s.GenerateToken(user, current.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenResponse{
+		Token:        access,
+		RefreshToken: raw,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *JwtServiceImpl) newRefreshToken(userID, familyID uuid.UUID) (string, *models.RefreshToken, error) {
+	raw, hash, err := newOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, &models.RefreshToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}, nil
+}
+
+// touchSession records that the session was used, at most once per
+// sessionTouchInterval, without delaying the request.
+func (s *JwtServiceImpl) touchSession(sessionID uuid.UUID) {
+	now := time.Now()
+	if last, ok := s.lastTouched.Load(sessionID); ok && now.Sub(last.(time.Time)) < sessionTouchInterval {
+		return
+	}
+	s.lastTouched.Store(sessionID, now)
+	go func() {
+		if err := s.sessions.Touch(sessionID, now); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("Error updating session last seen: ", err)
+		}
+	}()
+}
+
+func newSession(userID, sessionID uuid.UUID, client models.ClientInfo) *models.Session {
+	now := time.Now()
+	return &models.Session{
+		ID:         sessionID,
+		UserID:     userID,
+		UserAgent:  truncate(client.UserAgent, 512),
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return value[:max]
+}
+
+func (s *JwtServiceImpl) revokeReusedFamily(token *models.RefreshToken) error {
+	log.Printf("Refresh token reuse detected for user %s, revoking family %s", token.UserID, token.FamilyID)
+	if err := s.endSession(token.UserID, token.FamilyID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	if err := s.refreshRepo.RevokeFamily(token.FamilyID); err != nil {
+		return err
+	}
+	return ErrInvalidRefreshToken
+}
+
+func (s *JwtServiceImpl) JWKS() models.JSONWebKeySet {
+	return models.JSONWebKeySet{Keys: s.keys.publicKeys()}
+}
+
+// Discovery describes how other services can validate our access tokens.
+func (s *JwtServiceImpl) Discovery() models.OpenIDConfiguration {
+	issuer := strings.TrimSuffix(s.keys.issuer, "/")
+
+	algs := []string{}
+	for _, key := range s.keys.publicKeys() {
+		if !slices.Contains(algs, key.Alg) {
+			algs = append(algs, key.Alg)
+		}
+	}
+
+	return models.OpenIDConfiguration{
+		Issuer:                           issuer,
+		JwksURI:                          issuer + "/.well-known/jwks.json",
+		TokenEndpoint:                    issuer + "/auth/sign-in",
+		RevocationEndpoint:               issuer + "/auth/logout",
+		GrantTypesSupported:              []string{"password", "refresh_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: algs,
+		ClaimsSupported:                  []string{"sub", "iss", "iat", "exp", "jti", "sid", "user_id", "username", "role", "email_verified"},
+	}
 }
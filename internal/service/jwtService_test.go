@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+type fakeSessionRepo struct {
+	repository.SessionRepository
+	sessions map[uuid.UUID]*models.Session
+}
+
+func (repo *fakeSessionRepo) Create(session *models.Session) error {
+	repo.sessions[session.ID] = session
+	return nil
+}
+
+func (repo *fakeSessionRepo) ListActive(userID uuid.UUID, since time.Time) ([]models.Session, error) {
+	var active []models.Session
+	for _, session := range repo.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.LastSeenAt.After(since) {
+			active = append(active, *session)
+		}
+	}
+	return active, nil
+}
+
+func (repo *fakeSessionRepo) Touch(id uuid.UUID, at time.Time) error {
+	return nil
+}
+
+func (repo *fakeSessionRepo) RevokeByUser(userID uuid.UUID) error {
+	now := time.Now()
+	for _, session := range repo.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+type fakeRefreshRepo struct {
+	repository.RefreshTokenRepository
+}
+
+func (fakeRefreshRepo) Create(token *models.RefreshToken) error { return nil }
+
+func (fakeRefreshRepo) RevokeByUser(userID uuid.UUID) error { return nil }
+
+func newTestJwtService(t *testing.T, users ...*models.User) JwtService {
+	t.Helper()
+	keys := newTestKeySet(t)
+	sessions := &fakeSessionRepo{sessions: map[uuid.UUID]*models.Session{}}
+	return NewJwtService(keys, newFakeUserRepo(users...), fakeRefreshRepo{}, repository.NewMemoryRevocationStore(), sessions)
+}
+
+// startOfSecond waits until early in a second, so the steps that follow
+// run within the same second.
+func startOfSecond() {
+	if offset := time.Duration(time.Now().Nanosecond()); offset > 200*time.Millisecond {
+		time.Sleep(time.Second - offset)
+	}
+}
+
+func TestLogoutAllRevokesEarlierTokensInTheSameSecond(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: "user"}
+	jwtService := newTestJwtService(t, user)
+
+	startOfSecond()
+	before, err := jwtService.IssueTokens(user, models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jwtService.LogoutAll(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwtService.ParseToken(before.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token issued before LogoutAll: error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestLogoutAllKeepsLaterTokensInTheSameSecond(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: "user"}
+	jwtService := newTestJwtService(t, user)
+
+	startOfSecond()
+	if err := jwtService.LogoutAll(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	after, err := jwtService.IssueTokens(user, models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := jwtService.ParseToken(after.Token)
+	if err != nil {
+		t.Fatalf("token issued after LogoutAll: error = %v", err)
+	}
+	if identity.UserID != user.ID {
+		t.Fatalf("identity.UserID = %v, want %v", identity.UserID, user.ID)
+	}
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/qsheker/ToDo-app/internal/models"
+)
+
+const minSecretLength = 32
+
+// accessTokenType is the typ header of access tokens (RFC 9068). Tokens the
+// server only hands to itself carry their own type and the internal key.
+const accessTokenType = "at+jwt"
+
+// publishedSecrets were committed to the repository at some point, so
+// anyone can sign tokens with them.
+var publishedSecrets = []string{
+	"fbce1ceef702296950744b17f161021bc9bcee13bb9063a2b524eef6f3c285dc",
+}
+
+// SigningKeyConfig describes one key of the set. HS256 keys take a secret
+// from a file, an environment variable or, for tests, inline; RS256 and
+// EdDSA keys take a PEM private key. A key configured with only a
+// public key can verify tokens but never sign them, which is how a retired
+// key is kept around until its last tokens expire.
+type SigningKeyConfig struct {
+	ID             string `mapstructure:"kid"`
+	Algorithm      string `mapstructure:"alg"`
+	Secret         string `mapstructure:"secret"`
+	SecretFile     string `mapstructure:"secret_file"`
+	SecretEnv      string `mapstructure:"secret_env"`
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKeyFile  string `mapstructure:"public_key_file"`
+}
+
+type KeySetConfig struct {
+	Issuer      string             `mapstructure:"issuer"`
+	Audience    string             `mapstructure:"audience"`
+	ActiveKeyID string             `mapstructure:"active_kid"`
+	Keys        []SigningKeyConfig `mapstructure:"keys"`
+	// Internal is the HS256 key for tokens the server only hands to itself,
+	// such as 2FA challenges, verification links and OIDC login state.
+	Internal SigningKeyConfig `mapstructure:"internal"`
+}
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet signs access tokens with its active key and verifies tokens signed
+// by any of its keys. Internal tokens use a separate key that is never published.
+type KeySet struct {
+	issuer   string
+	audience string
+	active   *signingKey
+	keys     map[string]*signingKey
+	internal *signingKey
+}
+
+func NewKeySet(cfg KeySetConfig) (*KeySet, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("jwt: no signing keys configured")
+	}
+
+	ks := &KeySet{issuer: cfg.Issuer, audience: cfg.Audience, keys: make(map[string]*signingKey, len(cfg.Keys))}
+	for _, keyCfg := range cfg.Keys {
+		if keyCfg.ID == "" {
+			return nil, errors.New("jwt: signing key without kid")
+		}
+		if _, ok := ks.keys[keyCfg.ID]; ok {
+			return nil, fmt.Errorf("jwt: duplicate kid %q", keyCfg.ID)
+		}
+		key, err := loadSigningKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", keyCfg.ID, err)
+		}
+		ks.keys[key.id] = key
+	}
+
+	active, ok := ks.keys[cfg.ActiveKeyID]
+	if !ok {
+		return nil, fmt.Errorf("jwt: active kid %q is not in the key set", cfg.ActiveKeyID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("jwt: active kid %q has no private key", cfg.ActiveKeyID)
+	}
+	ks.active = active
+
+	internal, err := loadInternalKey(cfg.Internal)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: internal key: %w", err)
+	}
+	for _, key := range ks.keys {
+		if secret, ok := key.verifyKey.([]byte); ok && bytes.Equal(secret, internal.verifyKey.([]byte)) {
+			return nil, fmt.Errorf("jwt: internal key reuses the secret of kid %q", key.id)
+		}
+	}
+	ks.internal = internal
+	return ks, nil
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.id
+	token.Header["typ"] = accessTokenType
+	return token.SignedString(ks.active.signKey)
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	if typ, _ := token.Header["typ"].(string); typ != accessTokenType {
+		return nil, fmt.Errorf("unexpected token type %q", typ)
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for kid %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// signInternal signs a token of the given type with the internal key.
+func (ks *KeySet) signInternal(typ string, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.internal.method, claims)
+	token.Header["kid"] = ks.internal.id
+	token.Header["typ"] = typ
+	return token.SignedString(ks.internal.signKey)
+}
+
+// parseInternal verifies a token of the given type signed with the internal key.
+func (ks *KeySet) parseInternal(tokenString, typ string, claims jwt.Claims, options ...jwt.ParserOption) error {
+	options = append(options, jwt.WithValidMethods([]string{ks.internal.method.Alg()}))
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if got, _ := token.Header["typ"].(string); got != typ {
+			return nil, fmt.Errorf("unexpected token type %q", got)
+		}
+		if kid, _ := token.Header["kid"].(string); kid != ks.internal.id {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return ks.internal.verifyKey, nil
+	}, options...)
+	return err
+}
+
+func (ks *KeySet) algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range ks.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// publicKeys returns the asymmetric keys of the set as JWKs. HMAC secrets are never published.
+func (ks *KeySet) publicKeys() []models.JSONWebKey {
+	jwks := make([]models.JSONWebKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, models.JSONWebKey{
+				Kty: "RSA",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, models.JSONWebKey{
+				Kty: "OKP",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks
+}
+
+func loadSigningKey(cfg SigningKeyConfig) (*signingKey, error) {
+	key := &signingKey{id: cfg.ID}
+
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "HS256":
+		key.method = jwt.SigningMethodHS256
+		secret, err := readSecret(cfg)
+		if err != nil {
+			return nil, err
+		}
+		key.signKey, key.verifyKey = secret, secret
+
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if cfg.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey, key.verifyKey = private, &private.PublicKey
+		} else {
+			pem, err := readPublicKey(cfg)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	case "EDDSA":
+		key.method = jwt.SigningMethodEdDSA
+		if cfg.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey, key.verifyKey = private, private.(ed25519.PrivateKey).Public()
+		} else {
+			pem, err := readPublicKey(cfg)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Algorithm)
+	}
+
+	return key, nil
+}
+
+func loadInternalKey(cfg SigningKeyConfig) (*signingKey, error) {
+	if cfg.Algorithm != "" && !strings.EqualFold(cfg.Algorithm, "HS256") {
+		return nil, fmt.Errorf("unsupported algorithm %q, the internal key is HS256", cfg.Algorithm)
+	}
+	if cfg.ID == "" {
+		cfg.ID = "internal"
+	}
+	cfg.Algorithm = "HS256"
+	return loadSigningKey(cfg)
+}
+
+func readSecret(cfg SigningKeyConfig) ([]byte, error) {
+	var secret string
+	switch {
+	case cfg.SecretFile != "":
+		data, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = strings.TrimSpace(string(data))
+	case cfg.SecretEnv != "":
+		secret = strings.TrimSpace(os.Getenv(cfg.SecretEnv))
+		if secret == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", cfg.SecretEnv)
+		}
+	case cfg.Secret != "":
+		secret = cfg.Secret
+	default:
+		return nil, errors.New("secret_file or secret_env is required")
+	}
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("secret must be at least %d bytes", minSecretLength)
+	}
+	if slices.Contains(publishedSecrets, secret) {
+		return nil, errors.New("secret has been published and must be replaced")
+	}
+	return []byte(secret), nil
+}
+
+func readPublicKey(cfg SigningKeyConfig) ([]byte, error) {
+	if cfg.PublicKeyFile == "" {
+		return nil, errors.New("private_key_file or public_key_file is required")
+	}
+	return os.ReadFile(cfg.PublicKeyFile)
+}
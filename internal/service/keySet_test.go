@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+)
+
+func newTestKeySet(t *testing.T) *KeySet {
+	t.Helper()
+	keys, err := NewKeySet(KeySetConfig{
+		Issuer:      "http://localhost",
+		Audience:    "todo-api",
+		ActiveKeyID: "test",
+		Keys:        []SigningKeyConfig{{ID: "test", Algorithm: "HS256", Secret: strings.Repeat("k", minSecretLength)}},
+		Internal:    SigningKeyConfig{Secret: strings.Repeat("i", minSecretLength)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestNewKeySetRejectsUnsafeSecrets(t *testing.T) {
+	t.Setenv("TEST_JWT_SECRET", publishedSecrets[0])
+	tests := []struct {
+		name string
+		key  SigningKeyConfig
+	}{
+		{"missing", SigningKeyConfig{}},
+		{"unset env", SigningKeyConfig{SecretEnv: "TEST_JWT_SECRET_UNSET"}},
+		{"too short", SigningKeyConfig{Secret: "short"}},
+		{"published", SigningKeyConfig{Secret: publishedSecrets[0]}},
+		{"published in env", SigningKeyConfig{SecretEnv: "TEST_JWT_SECRET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.key.ID, tt.key.Algorithm = "hs", "HS256"
+			internal := SigningKeyConfig{Secret: strings.Repeat("i", minSecretLength)}
+			if _, err := NewKeySet(KeySetConfig{ActiveKeyID: "hs", Keys: []SigningKeyConfig{tt.key}, Internal: internal}); err == nil {
+				t.Fatal("NewKeySet() accepted the secret")
+			}
+		})
+	}
+}
+
+func TestNewKeySetReadsSecretFromEnv(t *testing.T) {
+	t.Setenv("TEST_JWT_SECRET", strings.Repeat("s", minSecretLength))
+	_, err := NewKeySet(KeySetConfig{
+		ActiveKeyID: "hs",
+		Keys:        []SigningKeyConfig{{ID: "hs", Algorithm: "HS256", SecretEnv: "TEST_JWT_SECRET"}},
+		Internal:    SigningKeyConfig{Secret: strings.Repeat("i", minSecretLength)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewKeySetRequiresSeparateInternalKey(t *testing.T) {
+	secret := strings.Repeat("s", minSecretLength)
+	keys := []SigningKeyConfig{{ID: "hs", Algorithm: "HS256", Secret: secret}}
+	tests := []struct {
+		name     string
+		internal SigningKeyConfig
+	}{
+		{"missing", SigningKeyConfig{}},
+		{"reused", SigningKeyConfig{Secret: secret}},
+		{"asymmetric", SigningKeyConfig{Algorithm: "RS256", Secret: strings.Repeat("i", minSecretLength)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeySet(KeySetConfig{ActiveKeyID: "hs", Keys: keys, Internal: tt.internal}); err == nil {
+				t.Fatal("NewKeySet() accepted the internal key")
+			}
+		})
+	}
+}
+
+func TestInternalTokensAreNotAccessTokens(t *testing.T) {
+	keys := newTestKeySet(t)
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: "user"}
+	jwtService := NewJwtService(keys, newFakeUserRepo(user), fakeRefreshRepo{}, nil, nil)
+
+	// A helper token carrying every access token claim is still rejected.
+	internal, err := keys.signInternal(twoFactorChallengeType, tokenClaims{
+		UserID: user.ID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    keys.issuer,
+			Audience:  jwt.ClaimStrings{keys.audience},
+			Subject:   user.ID.String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwtService.ParseToken(internal); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("ParseToken(internal token) error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	access, err := jwtService.GenerateToken(user, uuid.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.parseInternal(access, twoFactorChallengeType, &jwt.RegisteredClaims{}); err == nil {
+		t.Fatal("parseInternal() accepted an access token")
+	}
+	if err := keys.parseInternal(internal, emailVerificationType, &jwt.RegisteredClaims{}); err == nil {
+		t.Fatal("parseInternal() accepted a token of another type")
+	}
+}
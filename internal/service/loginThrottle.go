@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed sign-in attempts")
+
+// LockoutError is returned while a username or client IP is locked out.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockoutError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// LoginThrottleConfig controls when sign-in is locked. Once a key reaches
+// its threshold, every further failure locks it for BaseDelay, doubling up
+// to MaxDelay. IPs get their own, usually higher, threshold since many
+// users can share one address.
+type LoginThrottleConfig struct {
+	Threshold   int           `mapstructure:"threshold"`
+	IPThreshold int           `mapstructure:"ip_threshold"`
+	BaseDelay   time.Duration `mapstructure:"base_delay"`
+	MaxDelay    time.Duration `mapstructure:"max_delay"`
+	Window      time.Duration `mapstructure:"window"`
+}
+
+type LoginThrottle interface {
+	Check(username, ip string) error
+	Failure(username, ip string) error
+	Success(username string) error
+	Unlock(userID uuid.UUID) error
+}
+
+type LoginThrottleImpl struct {
+	cfg      LoginThrottleConfig
+	store    repository.AttemptStore
+	userRepo repository.UserRepository
+}
+
+func NewLoginThrottle(cfg LoginThrottleConfig, store repository.AttemptStore, userRepo repository.UserRepository) LoginThrottle {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 5
+	}
+	if cfg.IPThreshold <= 0 {
+		cfg.IPThreshold = 20
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = 30 * time.Second
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 15 * time.Minute
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Hour
+	}
+	return &LoginThrottleImpl{cfg: cfg, store: store, userRepo: userRepo}
+}
+
+// Check returns a *LockoutError if either the username or the IP is locked.
+func (t *LoginThrottleImpl) Check(username, ip string) error {
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, key := range t.keys(username, ip) {
+		until, err := t.store.LockedUntil(key)
+		if err != nil {
+			return err
+		}
+		if wait := until.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return &LockoutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Failure counts a failed attempt against the username and the IP. Either
+// may be empty, e.g. when a second factor fails for an unknown username.
+func (t *LoginThrottleImpl) Failure(username, ip string) error {
+	now := time.Now()
+	for _, key := range t.keys(username, ip) {
+		failures, err := t.store.RecordFailure(key, now, t.cfg.Window)
+		if err != nil {
+			return err
+		}
+
+		threshold := t.cfg.Threshold
+		if strings.HasPrefix(key, "ip:") {
+			threshold = t.cfg.IPThreshold
+		}
+		if failures < threshold {
+			continue
+		}
+
+		delay := t.delay(failures - threshold)
+		log.Printf("Locking sign-in for %s for %s after %d failures", key, delay, failures)
+		if err := t.store.Lock(key, now.Add(delay)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Success clears the username's counter. The IP counter is left to expire
+// so that one valid account cannot be used to reset it.
+func (t *LoginThrottleImpl) Success(username string) error {
+	return t.store.Reset(usernameAttemptKey(username))
+}
+
+func (t *LoginThrottleImpl) Unlock(userID uuid.UUID) error {
+	user, err := t.userRepo.GetByID(userID)
+	if err != nil {
+		return notFoundAs(err, ErrUserNotFound)
+	}
+	return t.store.Reset(usernameAttemptKey(user.Username))
+}
+
+func (t *LoginThrottleImpl) delay(excess int) time.Duration {
+	delay := t.cfg.BaseDelay
+	for i := 0; i < excess && delay < t.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.cfg.MaxDelay {
+		delay = t.cfg.MaxDelay
+	}
+	return delay
+}
+
+func (t *LoginThrottleImpl) keys(username, ip string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, usernameAttemptKey(username))
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+func usernameAttemptKey(username string) string {
+	return "user:" + models.NormalizeUsername(username)
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcLoginTTL      = 10 * time.Minute
+	oidcLoginAudience = "oidc-login"
+	oidcLoginType     = "oidc-login+jwt"
+)
+
+var (
+	ErrInvalidOIDCLogin    = errors.New("invalid or expired sign-in attempt, please start again")
+	ErrOIDCAccountNotFound = errors.New("no account is linked to this identity")
+	ErrOIDCEmailConflict   = errors.New("an account with this email already exists, sign in with your password first")
+)
+
+var usernameCleaner = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// OIDCConfig describes the external identity provider. With AutoProvision
+// off, only identities that are already linked can sign in. LinkByEmail
+// links a new identity to the local user with the same email, but only if
+// both the provider and the local account have verified the address.
+type OIDCConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	IssuerURL     string   `mapstructure:"issuer_url"`
+	ClientID      string   `mapstructure:"client_id"`
+	ClientSecret  string   `mapstructure:"client_secret"`
+	RedirectURL   string   `mapstructure:"redirect_url"`
+	Scopes        []string `mapstructure:"scopes"`
+	AutoProvision bool     `mapstructure:"auto_provision"`
+	LinkByEmail   bool     `mapstructure:"link_by_email"`
+}
+
+// OIDCLogin is a started sign-in. State is the signed value the browser
+// carries between the login and callback requests.
+type OIDCLogin struct {
+	AuthURL string
+	State   string
+}
+
+// oidcLoginClaims keep the state, nonce and PKCE verifier of a sign-in
+// attempt on the client, signed so they cannot be swapped.
+type oidcLoginClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+type OIDCService interface {
+	Begin() (*OIDCLogin, error)
+	Complete(ctx context.Context, login, state, code string) (*models.User, error)
+}
+
+type OIDCServiceImpl struct {
+	cfg          OIDCConfig
+	oauth        *oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+	keys         *KeySet
+	userRepo     repository.UserRepository
+	identityRepo repository.ExternalIdentityRepository
+}
+
+// NewOIDCService fetches the provider's discovery document, so the provider
+// has to be reachable at startup.
+func NewOIDCService(ctx context.Context, cfg OIDCConfig, keys *KeySet, userRepo repository.UserRepository, identityRepo repository.ExternalIdentityRepository) (OIDCService, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %w", err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OIDCServiceImpl{
+		cfg: cfg,
+		oauth: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:     provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		keys:         keys,
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+	}, nil
+}
+
+func (s *OIDCServiceImpl) Begin() (*OIDCLogin, error) {
+	state, _, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, _, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	now := time.Now()
+	signed, err := s.keys.signInternal(oidcLoginType, oidcLoginClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oidcLoginAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(oidcLoginTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCLogin{
+		AuthURL: s.oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)),
+		State:   signed,
+	}, nil
+}
+
+// Complete exchanges the authorization code, validates the ID token against
+// the signed login state and returns the local user for it.
+func (s *OIDCServiceImpl) Complete(ctx context.Context, login, state, code string) (*models.User, error) {
+	var claims oidcLoginClaims
+	err := s.keys.parseInternal(login, oidcLoginType, &claims,
+		jwt.WithAudience(oidcLoginAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || subtle.ConstantTimeCompare([]byte(claims.State), []byte(state)) != 1 {
+		return nil, ErrInvalidOIDCLogin
+	}
+
+	token, err := s.oauth.Exchange(ctx, code, oauth2.VerifierOption(claims.Verifier))
+	if err != nil {
+		log.Println("OIDC code exchange failed: ", err)
+		return nil, ErrInvalidOIDCLogin
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidOIDCLogin
+	}
+	idToken, err := s.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		log.Println("OIDC id token rejected: ", err)
+		return nil, ErrInvalidOIDCLogin
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(claims.Nonce)) != 1 {
+		return nil, ErrInvalidOIDCLogin
+	}
+
+	var profile oidcClaims
+	if err := idToken.Claims(&profile); err != nil {
+		return nil, err
+	}
+	return s.resolveUser(idToken.Issuer, idToken.Subject, &profile)
+}
+
+func (s *OIDCServiceImpl) resolveUser(issuer, subject string, profile *oidcClaims) (*models.User, error) {
+	identity, err := s.identityRepo.GetBySubject(issuer, subject)
+	if err == nil {
+		return s.userRepo.GetByID(identity.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var user *models.User
+	if profile.Email != "" {
+		existing, err := s.userRepo.GetByEmail(profile.Email)
+		switch {
+		case err == nil && s.cfg.LinkByEmail && profile.EmailVerified && existing.EmailVerified:
+			log.Printf("Linking %s identity %s to user %s", issuer, subject, existing.Username)
+			user = existing
+		case err == nil:
+			// Creating a second account with the same email would be
+			// confusing, and linking on an address either side has not
+			// verified would let whoever registered it first take over.
+			return nil, ErrOIDCEmailConflict
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		if !s.cfg.AutoProvision {
+			return nil, ErrOIDCAccountNotFound
+		}
+		if user, err = s.provision(profile); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.identityRepo.Create(&models.ExternalIdentity{
+		ID:      uuid.New(),
+		UserID:  user.ID,
+		Issuer:  issuer,
+		Subject: subject,
+		Email:   profile.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provision creates a local user for a new identity. The user has no
+// password and can only sign in through the provider until they reset it.
+func (s *OIDCServiceImpl) provision(profile *oidcClaims) (*models.User, error) {
+	username, err := s.availableUsername(profile)
+	if err != nil {
+		return nil, err
+	}
+	name := profile.Name
+	if name == "" {
+		name = username
+	}
+
+	user := &models.User{
+		ID:            uuid.New(),
+		Name:          name,
+		Username:      username,
+		Email:         profile.Email,
+		EmailVerified: profile.Email != "" && profile.EmailVerified,
+		Role:          models.RoleMember,
+	}
+	if err := s.userRepo.Create(user); err != nil {
+		return nil, err
+	}
+	log.Printf("Provisioned user %s from OIDC", user.Username)
+	return user, nil
+}
+
+// oidcUsernameSuffixLength is the length of the "-" and random suffix added
+// when the preferred username is taken.
+const oidcUsernameSuffixLength = 7
+
+// availableUsername derives a free username from the profile that passes
+// the same checks as a username chosen at sign-up.
+func (s *OIDCServiceImpl) availableUsername(profile *oidcClaims) (string, error) {
+	base := profile.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(profile.Email, "@")
+	}
+	base = strings.Trim(usernameCleaner.ReplaceAllString(strings.ToLower(base), ""), "._-")
+	if len(base) > maxUsernameLength-oidcUsernameSuffixLength {
+		base = strings.TrimRight(base[:maxUsernameLength-oidcUsernameSuffixLength], "._-")
+	}
+	switch {
+	case base == "":
+		base = "user"
+	case len(base) < minUsernameLength:
+		base = "user-" + base
+	}
+
+	next := base
+	for i := 0; i < 5; i++ {
+		candidate, err := checkUsername(next)
+		if err != nil {
+			return "", err
+		}
+		_, err = s.userRepo.GetByUsername(candidate)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Old names of other users are skipped so their links keep working.
+			_, err = s.userRepo.GetUsernameAlias(candidate)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return candidate, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		next = base + "-" + uuid.NewString()[:oidcUsernameSuffixLength-1]
+	}
+	return "", errors.New("could not find a free username for " + base)
+}
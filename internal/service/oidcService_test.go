@@ -0,0 +1,496 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+const testClientID = "todo-app"
+
+// mockIdP is an in-process OpenID provider with discovery, JWKS and a token
+// endpoint that checks the PKCE verifier. The authorization step is skipped:
+// authorize registers a code for a login as if the user had signed in.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, grants: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := idp.server.URL
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	public := idp.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "idp",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   testClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": grant.nonce,
+	}
+	for name, value := range grant.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "idp"
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// authorize plays the user signing in at the provider for the login's
+// authorization URL and returns the code the provider would redirect with.
+func (idp *mockIdP) authorize(t *testing.T, login *OIDCLogin, claims jwt.MapClaims) string {
+	t.Helper()
+	authURL, err := url.Parse(login.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+
+	code := uuid.NewString()
+	idp.mu.Lock()
+	idp.grants[code] = mockGrant{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		claims:    claims,
+	}
+	idp.mu.Unlock()
+	return code
+}
+
+// tamper changes a registered grant, for tests that need the provider to
+// misbehave.
+func (idp *mockIdP) tamper(code string, change func(*mockGrant)) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	grant := idp.grants[code]
+	change(&grant)
+	idp.grants[code] = grant
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	users   map[uuid.UUID]*models.User
+	aliases map[string]*models.UsernameAlias
+}
+
+func newFakeUserRepo(users ...*models.User) *fakeUserRepo {
+	repo := &fakeUserRepo{users: make(map[uuid.UUID]*models.User), aliases: make(map[string]*models.UsernameAlias)}
+	for _, user := range users {
+		repo.users[user.ID] = user
+	}
+	return repo
+}
+
+func (repo *fakeUserRepo) Create(user *models.User) error {
+	if _, err := repo.GetByUsername(user.Username); err == nil {
+		return gorm.ErrDuplicatedKey
+	}
+	repo.users[user.ID] = user
+	return nil
+}
+
+func (repo *fakeUserRepo) GetByID(id uuid.UUID) (*models.User, error) {
+	if user, ok := repo.users[id]; ok {
+		return user, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (repo *fakeUserRepo) GetByUsername(username string) (*models.User, error) {
+	for _, user := range repo.users {
+		if models.NormalizeUsername(user.Username) == models.NormalizeUsername(username) {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (repo *fakeUserRepo) GetUsernameAlias(username string) (*models.UsernameAlias, error) {
+	if alias, ok := repo.aliases[models.NormalizeUsername(username)]; ok {
+		return alias, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (repo *fakeUserRepo) GetByEmail(email string) (*models.User, error) {
+	for _, user := range repo.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeIdentityRepo struct {
+	repository.ExternalIdentityRepository
+	identities []models.ExternalIdentity
+}
+
+func (repo *fakeIdentityRepo) Create(identity *models.ExternalIdentity) error {
+	repo.identities = append(repo.identities, *identity)
+	return nil
+}
+
+func (repo *fakeIdentityRepo) GetBySubject(issuer, subject string) (*models.ExternalIdentity, error) {
+	for i := range repo.identities {
+		if repo.identities[i].Issuer == issuer && repo.identities[i].Subject == subject {
+			return &repo.identities[i], nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type oidcFixture struct {
+	idp        *mockIdP
+	service    OIDCService
+	users      *fakeUserRepo
+	identities *fakeIdentityRepo
+}
+
+func newOIDCFixture(t *testing.T, cfg OIDCConfig, users ...*models.User) *oidcFixture {
+	t.Helper()
+	idp := newMockIdP(t)
+	keys := newTestKeySet(t)
+
+	cfg.Enabled = true
+	cfg.IssuerURL = idp.server.URL
+	cfg.ClientID = testClientID
+	cfg.ClientSecret = "secret"
+	cfg.RedirectURL = "http://localhost/auth/oidc/callback"
+
+	fixture := &oidcFixture{idp: idp, users: newFakeUserRepo(users...), identities: &fakeIdentityRepo{}}
+	var err error
+	fixture.service, err = NewOIDCService(context.Background(), cfg, keys, fixture.users, fixture.identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+// signIn runs a whole sign-in for the given ID token claims.
+func (f *oidcFixture) signIn(t *testing.T, claims jwt.MapClaims) (*models.User, error) {
+	t.Helper()
+	login, err := f.service.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := f.idp.authorize(t, login, claims)
+	return f.service.Complete(context.Background(), login.State, stateParam(t, login), code)
+}
+
+func stateParam(t *testing.T, login *OIDCLogin) string {
+	t.Helper()
+	authURL, err := url.Parse(login.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authURL.Query().Get("state")
+}
+
+func TestOIDCCompleteRejectsTamperedLogins(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, f *oidcFixture, login *OIDCLogin, code string) (loginState, state string)
+	}{
+		{
+			name: "state mismatch",
+			tamper: func(t *testing.T, f *oidcFixture, login *OIDCLogin, code string) (string, string) {
+				return login.State, "not-the-state"
+			},
+		},
+		{
+			name: "login cookie from another attempt",
+			tamper: func(t *testing.T, f *oidcFixture, login *OIDCLogin, code string) (string, string) {
+				other, err := f.service.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return other.State, stateParam(t, login)
+			},
+		},
+		{
+			name: "nonce mismatch",
+			tamper: func(t *testing.T, f *oidcFixture, login *OIDCLogin, code string) (string, string) {
+				f.idp.tamper(code, func(g *mockGrant) { g.nonce = "replayed-nonce" })
+				return login.State, stateParam(t, login)
+			},
+		},
+		{
+			name: "PKCE mismatch",
+			tamper: func(t *testing.T, f *oidcFixture, login *OIDCLogin, code string) (string, string) {
+				f.idp.tamper(code, func(g *mockGrant) { g.challenge = "attacker-challenge" })
+				return login.State, stateParam(t, login)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOIDCFixture(t, OIDCConfig{AutoProvision: true})
+			login, err := f.service.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := f.idp.authorize(t, login, jwt.MapClaims{"sub": "alice-sub", "preferred_username": "alice"})
+			loginState, state := tt.tamper(t, f, login, code)
+
+			_, err = f.service.Complete(context.Background(), loginState, state, code)
+			if !errors.Is(err, ErrInvalidOIDCLogin) {
+				t.Fatalf("Complete() error = %v, want %v", err, ErrInvalidOIDCLogin)
+			}
+			if len(f.users.users) != 0 || len(f.identities.identities) != 0 {
+				t.Fatal("rejected sign-in created a user or identity")
+			}
+		})
+	}
+}
+
+func TestOIDCCompleteUsesLinkedIdentity(t *testing.T) {
+	alice := &models.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com"}
+	f := newOIDCFixture(t, OIDCConfig{}, alice)
+	f.identities.identities = append(f.identities.identities, models.ExternalIdentity{
+		ID: uuid.New(), UserID: alice.ID, Issuer: f.idp.server.URL, Subject: "alice-sub",
+	})
+
+	// The profile no longer matches the account; the link is what counts.
+	user, err := f.signIn(t, jwt.MapClaims{"sub": "alice-sub", "preferred_username": "someone-else", "email": "new@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != alice.ID {
+		t.Fatalf("signed in as %s, want %s", user.ID, alice.ID)
+	}
+	if len(f.users.users) != 1 || len(f.identities.identities) != 1 {
+		t.Fatal("sign-in through a linked identity created a user or identity")
+	}
+}
+
+func TestOIDCCompleteLinksByEmail(t *testing.T) {
+	tests := []struct {
+		name            string
+		linkByEmail     bool
+		profileVerified bool
+		accountVerified bool
+		wantLink        bool
+	}{
+		{name: "both verified", linkByEmail: true, profileVerified: true, accountVerified: true, wantLink: true},
+		{name: "linking off", linkByEmail: false, profileVerified: true, accountVerified: true},
+		{name: "provider did not verify", linkByEmail: true, profileVerified: false, accountVerified: true},
+		{name: "account did not verify", linkByEmail: true, profileVerified: true, accountVerified: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := &models.User{ID: uuid.New(), Username: "alice", Email: "Alice@example.com", EmailVerified: tt.accountVerified}
+			f := newOIDCFixture(t, OIDCConfig{AutoProvision: true, LinkByEmail: tt.linkByEmail}, alice)
+
+			user, err := f.signIn(t, jwt.MapClaims{
+				"sub":            "alice-sub",
+				"email":          "alice@example.com",
+				"email_verified": tt.profileVerified,
+			})
+			if !tt.wantLink {
+				if !errors.Is(err, ErrOIDCEmailConflict) {
+					t.Fatalf("Complete() error = %v, want %v", err, ErrOIDCEmailConflict)
+				}
+				if len(f.users.users) != 1 || len(f.identities.identities) != 0 {
+					t.Fatal("refused sign-in created a user or identity")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != alice.ID {
+				t.Fatalf("signed in as %s, want %s", user.ID, alice.ID)
+			}
+			identity, err := f.identities.GetBySubject(f.idp.server.URL, "alice-sub")
+			if err != nil || identity.UserID != alice.ID {
+				t.Fatalf("identity not linked to alice: %+v, %v", identity, err)
+			}
+		})
+	}
+}
+
+func TestOIDCCompleteProvisionsUsers(t *testing.T) {
+	tests := []struct {
+		name         string
+		existing     []*models.User
+		aliases      []string
+		claims       jwt.MapClaims
+		wantUsername func(string) bool
+	}{
+		{
+			name:         "preferred username",
+			claims:       jwt.MapClaims{"sub": "s", "preferred_username": "Bob.Smith"},
+			wantUsername: func(u string) bool { return u == "bob.smith" },
+		},
+		{
+			name:         "username taken ignoring case",
+			existing:     []*models.User{{ID: uuid.New(), Username: "Bob"}},
+			claims:       jwt.MapClaims{"sub": "s", "preferred_username": "bob"},
+			wantUsername: func(u string) bool { return strings.HasPrefix(u, "bob-") && len(u) == len("bob-")+6 },
+		},
+		{
+			name:         "username kept for a renamed user",
+			aliases:      []string{"bob"},
+			claims:       jwt.MapClaims{"sub": "s", "preferred_username": "bob"},
+			wantUsername: func(u string) bool { return strings.HasPrefix(u, "bob-") },
+		},
+		{
+			name:         "too short",
+			claims:       jwt.MapClaims{"sub": "s", "preferred_username": "ab"},
+			wantUsername: func(u string) bool { return u == "user-ab" },
+		},
+		{
+			name:         "nothing usable",
+			claims:       jwt.MapClaims{"sub": "s", "preferred_username": "---"},
+			wantUsername: func(u string) bool { return u == "user" },
+		},
+		{
+			name:         "from email",
+			claims:       jwt.MapClaims{"sub": "s", "email": "carol+todo@example.com"},
+			wantUsername: func(u string) bool { return u == "caroltodo" },
+		},
+		{
+			name:         "too long",
+			claims:       jwt.MapClaims{"sub": "s", "preferred_username": strings.Repeat("d", 100)},
+			wantUsername: func(u string) bool { return len(u) <= maxUsernameLength-oidcUsernameSuffixLength },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOIDCFixture(t, OIDCConfig{AutoProvision: true}, tt.existing...)
+			for _, alias := range tt.aliases {
+				f.users.aliases[alias] = &models.UsernameAlias{Key: alias, Username: alias, UserID: uuid.New()}
+			}
+
+			user, err := f.signIn(t, tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantUsername(user.Username) {
+				t.Fatalf("provisioned username %q", user.Username)
+			}
+			if _, err := checkUsername(user.Username); err != nil {
+				t.Fatalf("provisioned username %q fails sign-up checks: %v", user.Username, err)
+			}
+			if user.Password != "" || user.Role != models.RoleMember {
+				t.Fatalf("provisioned user has password %q and role %q", user.Password, user.Role)
+			}
+			if len(f.identities.identities) != 1 || f.identities.identities[0].UserID != user.ID {
+				t.Fatal("provisioned user was not linked to the identity")
+			}
+		})
+	}
+}
+
+func TestOIDCCompleteWithoutAutoProvision(t *testing.T) {
+	f := newOIDCFixture(t, OIDCConfig{AutoProvision: false})
+	_, err := f.signIn(t, jwt.MapClaims{"sub": "s", "preferred_username": "bob"})
+	if !errors.Is(err, ErrOIDCAccountNotFound) {
+		t.Fatalf("Complete() error = %v, want %v", err, ErrOIDCAccountNotFound)
+	}
+}
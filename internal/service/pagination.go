@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/qsheker/ToDo-app/internal/models"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+
+	defaultTodoSort = "-created_at"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+)
+
+// cursorToken is what an opaque cursor decodes to. Clients must not rely on
+// the format; it is only base64 so it survives being put in a query string.
+// Sort is the sort order the cursor was made for, as the canonical string.
+type cursorToken struct {
+	Sort     string            `json:"s"`
+	Values   []json.RawMessage `json:"v"`
+	Backward bool              `json:"b,omitempty"`
+}
+
+// parseTodoSort turns "-updated_at,title" into sort fields and appends id as
+// a tie-breaker unless it is already there. It also returns the canonical
+// form, which cursors are tied to.
+func parseTodoSort(sort string) ([]models.SortField, string, error) {
+	if strings.TrimSpace(sort) == "" {
+		sort = defaultTodoSort
+	}
+
+	var fields []models.SortField
+	var canonical []string
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		field := models.SortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+		if !slices.Contains(models.TodoSortFields, field.Field) {
+			return nil, "", fmt.Errorf("%w: unknown field %q, use one of %s", ErrInvalidSort, field.Field, strings.Join(models.TodoSortFields, ", "))
+		}
+		if slices.ContainsFunc(fields, func(f models.SortField) bool { return f.Field == field.Field }) {
+			return nil, "", fmt.Errorf("%w: field %q given twice", ErrInvalidSort, field.Field)
+		}
+		fields = append(fields, field)
+		canonical = append(canonical, part)
+	}
+	if !slices.ContainsFunc(fields, func(f models.SortField) bool { return f.Field == "id" }) {
+		fields = append(fields, models.SortField{Field: "id", Desc: true})
+	}
+	return fields, strings.Join(canonical, ","), nil
+}
+
+// todoSortValue returns the value of a sort field for the keyset comparison.
+func todoSortValue(todo *models.Todo, field string) interface{} {
+	switch field {
+	case "created_at":
+		return todo.CreatedAt
+	case "updated_at":
+		return todo.UpdatedAt
+	case "title":
+		return todo.Title
+	case "completed":
+		return todo.Completed
+	case "priority":
+		return int16(todo.Priority)
+	case "rank":
+		return todo.Rank
+	default:
+		return todo.ID
+	}
+}
+
+func encodeTodoCursor(todo *models.Todo, sort []models.SortField, canonical string, backward bool) string {
+	token := cursorToken{Sort: canonical, Backward: backward}
+	for _, field := range sort {
+		value, _ := json.Marshal(todoSortValue(todo, field.Field))
+		token.Values = append(token.Values, value)
+	}
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeTodoCursor reads a cursor made for the given sort order, decoding
+// each value into the type of its field.
+func decodeTodoCursor(cursor string, sort []models.SortField, canonical string) (*models.TodoCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var token cursorToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if token.Sort != canonical || len(token.Values) != len(sort) {
+		return nil, fmt.Errorf("%w: it was made for sort=%s", ErrInvalidCursor, token.Sort)
+	}
+
+	decoded := &models.TodoCursor{Backward: token.Backward}
+	for i, field := range sort {
+		var value interface{}
+		switch field.Field {
+		case "created_at", "updated_at":
+			var t time.Time
+			err = json.Unmarshal(token.Values[i], &t)
+			value = t
+		case "title", "rank":
+			var text string
+			err = json.Unmarshal(token.Values[i], &text)
+			value = text
+		case "completed":
+			var completed bool
+			err = json.Unmarshal(token.Values[i], &completed)
+			value = completed
+		case "priority":
+			var priority int16
+			err = json.Unmarshal(token.Values[i], &priority)
+			value = priority
+		default:
+			var id int64
+			err = json.Unmarshal(token.Values[i], &id)
+			value = id
+		}
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		decoded.Values = append(decoded.Values, value)
+	}
+	return decoded, nil
+}
+
+// pageLimit applies the default page size and caps it at MaxPageSize.
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	return min(limit, MaxPageSize)
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError explains why one request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found in a request at once, so a
+// client can show them all next to the form fields.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// PasswordPolicyConfig configures the password rules. HistorySize is how
+// many previous passwords may not be reused. BreachedDir holds a breached
+// password list split by SHA-1 prefix, see BreachedPasswordList.
+type PasswordPolicyConfig struct {
+	MinLength     int    `mapstructure:"min_length"`
+	MaxLength     int    `mapstructure:"max_length"`
+	RequireUpper  bool   `mapstructure:"require_upper"`
+	RequireLower  bool   `mapstructure:"require_lower"`
+	RequireDigit  bool   `mapstructure:"require_digit"`
+	RequireSymbol bool   `mapstructure:"require_symbol"`
+	HistorySize   int    `mapstructure:"history_size"`
+	BreachedDir   string `mapstructure:"breached_dir"`
+}
+
+// PasswordPolicy checks a candidate password for the given username. Reuse
+// of old passwords is checked by UserService, which knows the history.
+type PasswordPolicy struct {
+	cfg      PasswordPolicyConfig
+	breached *BreachedPasswordList
+}
+
+func NewPasswordPolicy(cfg PasswordPolicyConfig) *PasswordPolicy {
+	if cfg.MinLength <= 0 {
+		cfg.MinLength = 8
+	}
+	if cfg.MaxLength <= 0 {
+		// Caps the work an attacker can make the hasher do per request.
+		cfg.MaxLength = 128
+	}
+	policy := &PasswordPolicy{cfg: cfg}
+	if cfg.BreachedDir != "" {
+		policy.breached = NewBreachedPasswordList(cfg.BreachedDir)
+	}
+	return policy
+}
+
+func (p *PasswordPolicy) HistorySize() int {
+	return p.cfg.HistorySize
+}
+
+// Check returns a *ValidationError listing every rule the password breaks.
+func (p *PasswordPolicy) Check(password, username string) error {
+	verr := &ValidationError{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.cfg.MinLength {
+		verr.add("password", fmt.Sprintf("must be at least %d characters long", p.cfg.MinLength))
+	}
+	if len(password) > p.cfg.MaxLength {
+		verr.add("password", fmt.Sprintf("must be at most %d bytes long", p.cfg.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		verr.add("password", "must contain an uppercase letter")
+	}
+	if p.cfg.RequireLower && !lower {
+		verr.add("password", "must contain a lowercase letter")
+	}
+	if p.cfg.RequireDigit && !digit {
+		verr.add("password", "must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		verr.add("password", "must contain a symbol")
+	}
+
+	// Very short usernames would match too many passwords by accident.
+	lowerPassword, lowerUsername := strings.ToLower(password), strings.ToLower(username)
+	if lowerUsername != "" && (lowerPassword == lowerUsername ||
+		len(lowerUsername) >= 4 && strings.Contains(lowerPassword, lowerUsername)) {
+		verr.add("password", "must not contain the username")
+	}
+
+	if p.breached != nil {
+		found, err := p.breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if found {
+			verr.add("password", "appears in a list of breached passwords, choose another one")
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// BreachedPasswordList looks passwords up in a local copy of a breached
+// password list in the k-anonymity range format: the file named after the
+// first five hex digits of a password's SHA-1 (e.g. "5BAA6.txt") lists the
+// remaining 35 digits of each breached hash, one "SUFFIX:COUNT" per line.
+// Only that one small file is read per lookup.
+type BreachedPasswordList struct {
+	dir string
+}
+
+func NewBreachedPasswordList(dir string) *BreachedPasswordList {
+	return &BreachedPasswordList{dir: dir}
+}
+
+func (l *BreachedPasswordList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(l.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/mail"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	passwordResetTTL = time.Hour
+
+	// PasswordResetWindow is the window reset requests are counted in.
+	PasswordResetWindow = time.Hour
+	// maxResetRequestsPerAccount limits reset requests for one username or
+	// email address, so an account's inbox cannot be flooded.
+	maxResetRequestsPerAccount = 3
+	// maxResetRequestsPerIP limits reset requests from one client IP.
+	maxResetRequestsPerIP = 20
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrResetRateLimited  = errors.New("too many password reset requests, try again later")
+)
+
+type PasswordResetService interface {
+	Forgot(req *models.ForgotPasswordRequest, ip string) error
+	Reset(req *models.ResetPasswordRequest) error
+}
+
+type PasswordResetServiceImpl struct {
+	repo        repository.PasswordResetRepository
+	userRepo    repository.UserRepository
+	userService UserService
+	attempts    repository.AttemptStore
+	mailer      mail.Mailer
+	resetURL    string
+}
+
+func NewPasswordResetService(repo repository.PasswordResetRepository, userRepo repository.UserRepository, userService UserService, attempts repository.AttemptStore, mailer mail.Mailer, resetURL string) PasswordResetService {
+	return &PasswordResetServiceImpl{
+		repo:        repo,
+		userRepo:    userRepo,
+		userService: userService,
+		attempts:    attempts,
+		mailer:      mailer,
+		resetURL:    resetURL,
+	}
+}
+
+// Forgot mails a reset link to every account with the given username or
+// verified email address. Unverified addresses get nothing, since anyone
+// could have entered them. It reports success either way so callers cannot
+// probe for accounts, but requests per account and per IP are limited.
+func (s *PasswordResetServiceImpl) Forgot(req *models.ForgotPasswordRequest, ip string) error {
+	accountKey := "reset-user:" + models.NormalizeUsername(req.Username)
+	if req.Email != "" {
+		accountKey = "reset-email:" + strings.ToLower(strings.TrimSpace(req.Email))
+	}
+	if err := s.limit(accountKey, maxResetRequestsPerAccount); err != nil {
+		return err
+	}
+	if err := s.limit("reset-ip:"+ip, maxResetRequestsPerIP); err != nil {
+		return err
+	}
+
+	var users []models.User
+	if req.Email != "" {
+		var err error
+		if users, err = s.userRepo.ListByVerifiedEmail(req.Email); err != nil {
+			return err
+		}
+	} else {
+		user, err := s.userRepo.GetByUsername(req.Username)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if user.EmailVerified {
+			users = append(users, *user)
+		}
+	}
+
+	for i := range users {
+		if users[i].Email == "" {
+			continue
+		}
+		if err := s.sendResetLink(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limit counts a reset request against key and fails once more than max
+// were made within PasswordResetWindow.
+func (s *PasswordResetServiceImpl) limit(key string, max int) error {
+	requests, err := s.attempts.RecordFailure(key, time.Now(), PasswordResetWindow)
+	if err != nil {
+		return err
+	}
+	if requests > max {
+		return ErrResetRateLimited
+	}
+	return nil
+}
+
+func (s *PasswordResetServiceImpl) sendResetLink(user *models.User) error {
+	raw, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	token := &models.PasswordResetToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+	if err := s.repo.Create(token); err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your ToDo password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password for %s. It expires in %d minutes and works once.\n\n%s?token=%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Name, user.Username, int(passwordResetTTL.Minutes()), s.resetURL, url.QueryEscape(raw),
+		),
+	}
+	go func() {
+		if err := s.mailer.Send(msg); err != nil {
+			log.Println("Error sending password reset mail: ", err)
+		}
+	}()
+	return nil
+}
+
+// Reset consumes the token, sets the new password, signs the user out
+// everywhere and revokes their personal access tokens.
+func (s *PasswordResetServiceImpl) Reset(req *models.ResetPasswordRequest) error {
+	// Check the password before using up the token, so a rejected password
+	// can be corrected without requesting a new link.
+	pending, err := s.repo.GetValid(hashToken(req.Token), time.Now())
+	if err != nil {
+		return notFoundAs(err, ErrInvalidResetToken)
+	}
+	if err := s.userService.CheckPassword(pending.UserID, req.Password); err != nil {
+		return notFoundAs(err, ErrInvalidResetToken)
+	}
+
+	token, err := s.repo.Consume(hashToken(req.Token), time.Now())
+	if err != nil {
+		return notFoundAs(err, ErrInvalidResetToken)
+	}
+
+	if err := s.userService.SetPassword(token.UserID, req.Password); err != nil {
+		return notFoundAs(err, ErrInvalidResetToken)
+	}
+	if err := s.repo.InvalidateForUser(token.UserID); err != nil {
+		return err
+	}
+	return s.userService.RevokeCredentials(token.UserID)
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/mail"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+func (repo *fakeUserRepo) ListByVerifiedEmail(email string) ([]models.User, error) {
+	var users []models.User
+	for _, user := range repo.users {
+		if user.EmailVerified && strings.EqualFold(user.Email, email) {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+type fakeResetRepo struct {
+	repository.PasswordResetRepository
+}
+
+func (fakeResetRepo) Create(token *models.PasswordResetToken) error { return nil }
+
+// chanMailer hands sent messages to the test, since they are sent in the background.
+type chanMailer chan mail.Message
+
+func (m chanMailer) Send(msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func newTestResetService(users ...*models.User) (PasswordResetService, chanMailer) {
+	mailer := make(chanMailer, 10)
+	resetService := NewPasswordResetService(fakeResetRepo{}, newFakeUserRepo(users...), nil, repository.NewMemoryAttemptStore(), mailer, "http://localhost/reset")
+	return resetService, mailer
+}
+
+// sent returns the messages mailed, waiting briefly for background sends.
+func sent(mailer chanMailer) []mail.Message {
+	var messages []mail.Message
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case msg := <-mailer:
+			messages = append(messages, msg)
+		case <-timeout:
+			return messages
+		}
+	}
+}
+
+func TestForgotMailsEveryVerifiedAccount(t *testing.T) {
+	alice := &models.User{ID: uuid.New(), Username: "alice", Email: "shared@example.com", EmailVerified: true}
+	bob := &models.User{ID: uuid.New(), Username: "bob", Email: "Shared@example.com", EmailVerified: true}
+	mallory := &models.User{ID: uuid.New(), Username: "mallory", Email: "shared@example.com"}
+	resetService, mailer := newTestResetService(alice, bob, mallory)
+
+	if err := resetService.Forgot(&models.ForgotPasswordRequest{Email: "SHARED@example.com"}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, msg := range sent(mailer) {
+		for _, user := range []*models.User{alice, bob, mallory} {
+			if msg.To == user.Email && strings.Contains(msg.Body, " for "+user.Username+".") {
+				got = append(got, user.Username)
+			}
+		}
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != "alice,bob" {
+		t.Fatalf("mailed %v, want alice and bob", got)
+	}
+}
+
+func TestForgotSkipsUnverifiedEmail(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com"}
+	resetService, mailer := newTestResetService(user)
+
+	if err := resetService.Forgot(&models.ForgotPasswordRequest{Username: "alice"}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := resetService.Forgot(&models.ForgotPasswordRequest{Email: "alice@example.com"}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sent(mailer); len(got) != 0 {
+		t.Fatalf("mailed %v, want nobody", got)
+	}
+}
+
+func TestForgotIsRateLimited(t *testing.T) {
+	resetService, _ := newTestResetService()
+
+	for i := 0; i < maxResetRequestsPerAccount; i++ {
+		if err := resetService.Forgot(&models.ForgotPasswordRequest{Email: "alice@example.com"}, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := resetService.Forgot(&models.ForgotPasswordRequest{Email: " Alice@example.com"}, "192.0.2.2")
+	if !errors.Is(err, ErrResetRateLimited) {
+		t.Fatalf("Forgot() error = %v, want %v for the same email", err, ErrResetRateLimited)
+	}
+
+	// The rejected request came from another IP and did not count here.
+	for i := 0; i < maxResetRequestsPerIP-maxResetRequestsPerAccount; i++ {
+		if err := resetService.Forgot(&models.ForgotPasswordRequest{Username: uuid.NewString()}, "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = resetService.Forgot(&models.ForgotPasswordRequest{Username: "bob"}, "192.0.2.1")
+	if !errors.Is(err, ErrResetRateLimited) {
+		t.Fatalf("Forgot() error = %v, want %v for the same IP", err, ErrResetRateLimited)
+	}
+}
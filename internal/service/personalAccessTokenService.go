@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+// PersonalTokenPrefix tells personal access tokens apart from JWTs in the Authorization header.
+const PersonalTokenPrefix = "tdp_"
+
+var ErrPersonalTokenNotFound = errors.New("personal access token not found")
+
+type PersonalAccessTokenService interface {
+	Create(userID uuid.UUID, req *models.CreatePersonalAccessTokenRequest) (*models.CreatePersonalAccessTokenResponse, error)
+	List(userID uuid.UUID) ([]models.PersonalAccessToken, error)
+	Revoke(userID, id uuid.UUID) error
+	Authenticate(token string) (*Identity, error)
+}
+
+type PersonalAccessTokenServiceImpl struct {
+	repo     repository.PersonalAccessTokenRepository
+	userRepo repository.UserRepository
+}
+
+func NewPersonalAccessTokenService(repo repository.PersonalAccessTokenRepository, userRepo repository.UserRepository) PersonalAccessTokenService {
+	return &PersonalAccessTokenServiceImpl{repo: repo, userRepo: userRepo}
+}
+
+func (s *PersonalAccessTokenServiceImpl) Create(userID uuid.UUID, req *models.CreatePersonalAccessTokenRequest) (*models.CreatePersonalAccessTokenResponse, error) {
+	raw, _, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	token := PersonalTokenPrefix + raw
+
+	entity := &models.PersonalAccessToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    token[:len(PersonalTokenPrefix)+6],
+		TokenHash: hashToken(token),
+		Scopes:    req.Scopes,
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := s.repo.Create(entity); err != nil {
+		return nil, err
+	}
+
+	return &models.CreatePersonalAccessTokenResponse{PersonalAccessToken: *entity, Token: token}, nil
+}
+
+func (s *PersonalAccessTokenServiceImpl) List(userID uuid.UUID) ([]models.PersonalAccessToken, error) {
+	return s.repo.ListByUser(userID)
+}
+
+func (s *PersonalAccessTokenServiceImpl) Revoke(userID, id uuid.UUID) error {
+	return notFoundAs(s.repo.Revoke(userID, id), ErrPersonalTokenNotFound)
+}
+
+func (s *PersonalAccessTokenServiceImpl) Authenticate(token string) (*Identity, error) {
+	if !strings.HasPrefix(token, PersonalTokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+
+	entity, err := s.repo.GetByHash(hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if entity.RevokedAt != nil || now.After(entity.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.userRepo.GetByID(entity.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	// Accounts pending deletion are signed out; only a real sign-in brings them back.
+	if user.DeletionDueAt != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := s.repo.TouchLastUsed(entity.ID, now); err != nil {
+		log.Println("Error updating personal access token last use: ", err)
+	}
+
+	return &Identity{
+		UserID:          user.ID,
+		Username:        user.Username,
+		Role:            user.Role,
+		ExpiresAt:       entity.ExpiresAt,
+		EmailVerified:   user.EmailVerified,
+		PersonalTokenID: entity.ID,
+		Scopes:          entity.Scopes,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/notify"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+var ErrReminderNotFound = errors.New("reminder not found")
+
+const (
+	// reminderBatchSize limits how many reminders one scheduler run fires.
+	reminderBatchSize = 50
+	// maxReminderAttempts is how often a reminder is tried before it is
+	// marked failed.
+	maxReminderAttempts = 5
+	// reminderClaimLease is how long a claimed reminder is left to the
+	// server that claimed it before another one retries it.
+	reminderClaimLease = 5 * time.Minute
+)
+
+type ReminderService interface {
+	Create(userID uuid.UUID, todoID int64, req *models.ReminderRequest) (*models.Reminder, error)
+	List(userID uuid.UUID, todoID int64) ([]models.Reminder, error)
+	Delete(userID uuid.UUID, todoID, id int64) error
+	FireDue(now time.Time) (int, error)
+}
+
+type ReminderServiceImpl struct {
+	repo     repository.ReminderRepository
+	todoRepo repository.TodoRepository
+	userRepo repository.UserRepository
+	notifier notify.Notifier
+}
+
+func NewReminderService(repo repository.ReminderRepository, todoRepo repository.TodoRepository, userRepo repository.UserRepository, notifier notify.Notifier) ReminderService {
+	return &ReminderServiceImpl{repo: repo, todoRepo: todoRepo, userRepo: userRepo, notifier: notifier}
+}
+
+// Create adds a reminder to the todo. A reminder relative to the due date
+// of a todo without one waits until a due date is set.
+func (s *ReminderServiceImpl) Create(userID uuid.UUID, todoID int64, req *models.ReminderRequest) (*models.Reminder, error) {
+	todo, err := s.todoRepo.GetByID(userID, todoID)
+	if err != nil {
+		return nil, notFoundAs(err, ErrTodoNotFound)
+	}
+	if req.RemindAt != nil && !req.RemindAt.After(time.Now()) {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "remind_at", Message: "must be in the future"}}}
+	}
+
+	reminder := &models.Reminder{
+		TodoID:           todo.ID,
+		UserID:           userID,
+		MinutesBeforeDue: req.MinutesBeforeDue,
+	}
+	if req.RemindAt != nil {
+		remindAt := req.RemindAt.UTC()
+		reminder.RemindAt = &remindAt
+	}
+	reminder.FireAt = reminder.FireTime(todo.DueAt)
+	if err := s.repo.Create(reminder); err != nil {
+		return nil, err
+	}
+	return reminder, nil
+}
+
+func (s *ReminderServiceImpl) List(userID uuid.UUID, todoID int64) ([]models.Reminder, error) {
+	if _, err := s.todoRepo.GetByID(userID, todoID); err != nil {
+		return nil, notFoundAs(err, ErrTodoNotFound)
+	}
+	return s.repo.ListByTodo(userID, todoID)
+}
+
+func (s *ReminderServiceImpl) Delete(userID uuid.UUID, todoID, id int64) error {
+	return notFoundAs(s.repo.Delete(userID, todoID, id), ErrReminderNotFound)
+}
+
+// FireDue hands the reminders that are due to the notifier. Each one is
+// claimed before it is sent and marked sent afterwards. If the server dies
+// in between, the reminder is retried once the claim runs out with the same
+// idempotency key, which the notifier uses to drop repeats. A reminder that
+// still fails after maxReminderAttempts is marked failed.
+func (s *ReminderServiceImpl) FireDue(now time.Time) (int, error) {
+	reminders, err := s.repo.ClaimDue(now, reminderClaimLease, reminderBatchSize, maxReminderAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	fired := 0
+	for i := range reminders {
+		reminder := &reminders[i]
+		if err := s.send(reminder); err != nil {
+			log.Printf("Error sending reminder %d: %v", reminder.ID, err)
+			if reminder.Attempts >= maxReminderAttempts {
+				log.Printf("Giving up on reminder %d after %d attempts", reminder.ID, reminder.Attempts)
+				err = s.repo.MarkFailed(reminder.ID, *reminder.FireAt, time.Now())
+			} else {
+				err = s.repo.ReleaseClaim(reminder.ID)
+			}
+			if err != nil {
+				return fired, err
+			}
+			continue
+		}
+		if err := s.repo.MarkSent(reminder.ID, *reminder.FireAt, time.Now()); err != nil {
+			return fired, err
+		}
+		fired++
+	}
+	return fired, nil
+}
+
+func (s *ReminderServiceImpl) send(reminder *models.Reminder) error {
+	todo, err := s.todoRepo.GetByID(reminder.UserID, reminder.TodoID)
+	if err != nil {
+		return err
+	}
+	user, err := s.userRepo.GetByID(todo.UserID)
+	if err != nil {
+		return err
+	}
+	return s.notifier.Notify(notify.Reminder{
+		// Rescheduling makes a new firing, which gets a new key.
+		IdempotencyKey: fmt.Sprintf("reminder-%d-%d", reminder.ID, reminder.FireAt.Unix()),
+		ReminderID:     reminder.ID,
+		TodoID:         todo.ID,
+		Title:          todo.Title,
+		DueAt:          localDueAt(todo.DueAt, todo.DueTimeZone),
+		Name:           user.Name,
+		Email:          user.Email,
+	})
+}
+
+// StartReminderScheduler fires due reminders every interval until ctx is
+// done. A full batch is followed by another run right away.
+func StartReminderScheduler(ctx context.Context, reminders ReminderService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for {
+					fired, err := reminders.FireDue(now)
+					if err != nil {
+						log.Printf("Error firing reminders: %v", err)
+					}
+					if err != nil || fired < reminderBatchSize {
+						break
+					}
+					now = time.Now()
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/notify"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+// fakeReminderRepo claims its one reminder on every run, like ClaimDue
+// does once a claim was released.
+type fakeReminderRepo struct {
+	repository.ReminderRepository
+	reminder models.Reminder
+}
+
+func (repo *fakeReminderRepo) ClaimDue(now time.Time, lease time.Duration, limit, maxAttempts int) ([]models.Reminder, error) {
+	reminder := &repo.reminder
+	if reminder.SentAt != nil || reminder.FailedAt != nil || reminder.ClaimedUntil != nil {
+		return nil, nil
+	}
+	claimedUntil := now.Add(lease)
+	reminder.ClaimedUntil = &claimedUntil
+	reminder.Attempts++
+	return []models.Reminder{*reminder}, nil
+}
+
+func (repo *fakeReminderRepo) MarkSent(id int64, fireAt, now time.Time) error {
+	repo.reminder.SentAt, repo.reminder.ClaimedUntil = &now, nil
+	return nil
+}
+
+func (repo *fakeReminderRepo) MarkFailed(id int64, fireAt, now time.Time) error {
+	repo.reminder.FailedAt, repo.reminder.ClaimedUntil = &now, nil
+	return nil
+}
+
+func (repo *fakeReminderRepo) ReleaseClaim(id int64) error {
+	repo.reminder.ClaimedUntil = nil
+	return nil
+}
+
+type fakeTodoRepo struct {
+	repository.TodoRepository
+	todo models.Todo
+}
+
+func (repo *fakeTodoRepo) GetByID(userID uuid.UUID, id int64) (*models.Todo, error) {
+	todo := repo.todo
+	return &todo, nil
+}
+
+type failingNotifier struct{ calls int }
+
+func (n *failingNotifier) Notify(reminder notify.Reminder) error {
+	n.calls++
+	return errors.New("smtp down")
+}
+
+func TestFireDueMarksReminderFailedAfterMaxAttempts(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Username: "alice"}
+	fireAt := time.Now().Add(-time.Minute)
+	reminders := &fakeReminderRepo{reminder: models.Reminder{ID: 1, TodoID: 1, UserID: user.ID, FireAt: &fireAt}}
+	notifier := &failingNotifier{}
+	reminderService := NewReminderService(reminders, &fakeTodoRepo{todo: models.Todo{ID: 1, UserID: user.ID}}, newFakeUserRepo(user), notifier)
+
+	for i := 0; i < maxReminderAttempts+2; i++ {
+		if _, err := reminderService.FireDue(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if notifier.calls != maxReminderAttempts {
+		t.Fatalf("notifier called %d times, want %d", notifier.calls, maxReminderAttempts)
+	}
+	if reminders.reminder.FailedAt == nil || reminders.reminder.SentAt != nil {
+		t.Fatalf("reminder = %+v, want it failed and not sent", reminders.reminder)
+	}
+}
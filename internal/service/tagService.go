@@ -0,0 +1,143 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("a tag with this name already exists")
+)
+
+// defaultTagColor is used when a tag is created without a color.
+const defaultTagColor = "#9e9e9e"
+
+type TagService interface {
+	List(userID uuid.UUID) ([]models.TagSummary, error)
+	Create(userID uuid.UUID, req *models.TagRequest) (*models.Tag, error)
+	Update(userID uuid.UUID, id int64, req *models.TagRequest) (*models.Tag, error)
+	Delete(userID uuid.UUID, id int64) error
+	Attach(userID uuid.UUID, todoID, tagID int64) error
+	Detach(userID uuid.UUID, todoID, tagID int64) error
+}
+
+type TagServiceImpl struct {
+	repo     repository.TagRepository
+	todoRepo repository.TodoRepository
+}
+
+func NewTagService(repo repository.TagRepository, todoRepo repository.TodoRepository) TagService {
+	return &TagServiceImpl{repo: repo, todoRepo: todoRepo}
+}
+
+// List returns the user's tags by name with their todo counts.
+func (s *TagServiceImpl) List(userID uuid.UUID) ([]models.TagSummary, error) {
+	return s.repo.ListWithCounts(userID)
+}
+
+func (s *TagServiceImpl) Create(userID uuid.UUID, req *models.TagRequest) (*models.Tag, error) {
+	name, err := checkTagName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	tag := &models.Tag{
+		UserID: userID,
+		Name:   name,
+		Color:  strings.ToLower(req.Color),
+	}
+	if tag.Color == "" {
+		tag.Color = defaultTagColor
+	}
+	if err := s.repo.Create(tag); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrTagExists
+		}
+		return nil, err
+	}
+	return tag, nil
+}
+
+// Update renames or recolors a tag. Its todos refer to it by ID, so they
+// all show the change.
+func (s *TagServiceImpl) Update(userID uuid.UUID, id int64, req *models.TagRequest) (*models.Tag, error) {
+	name, err := checkTagName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := s.repo.GetByID(userID, id)
+	if err != nil {
+		return nil, notFoundAs(err, ErrTagNotFound)
+	}
+	tag.Name = name
+	if req.Color != "" {
+		tag.Color = strings.ToLower(req.Color)
+	}
+	tag.UpdatedAt = time.Now()
+	if err := s.repo.Update(tag); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrTagExists
+		}
+		return nil, err
+	}
+	return tag, nil
+}
+
+// Delete removes the tag and takes it off every todo.
+func (s *TagServiceImpl) Delete(userID uuid.UUID, id int64) error {
+	return notFoundAs(s.repo.Delete(userID, id), ErrTagNotFound)
+}
+
+func (s *TagServiceImpl) Attach(userID uuid.UUID, todoID, tagID int64) error {
+	if err := s.checkOwnership(userID, todoID, tagID); err != nil {
+		return err
+	}
+	return s.repo.Attach(todoID, tagID)
+}
+
+func (s *TagServiceImpl) Detach(userID uuid.UUID, todoID, tagID int64) error {
+	if err := s.checkOwnership(userID, todoID, tagID); err != nil {
+		return err
+	}
+	return s.repo.Detach(todoID, tagID)
+}
+
+// checkOwnership makes sure the todo and the tag both belong to the user.
+func (s *TagServiceImpl) checkOwnership(userID uuid.UUID, todoID, tagID int64) error {
+	if _, err := s.todoRepo.GetByID(userID, todoID); err != nil {
+		return notFoundAs(err, ErrTodoNotFound)
+	}
+	if _, err := s.repo.GetByID(userID, tagID); err != nil {
+		return notFoundAs(err, ErrTagNotFound)
+	}
+	return nil
+}
+
+// checkTagName returns the name without surrounding spaces, or a
+// *ValidationError if nothing is left.
+func checkTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", &ValidationError{Fields: []FieldError{{Field: "name", Message: "must not be blank"}}}
+	}
+	return name, nil
+}
+
+// splitTagNames turns a comma-separated tag filter into distinct names.
+func splitTagNames(names string) []string {
+	var result []string
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.Contains(result, name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
@@ -1,43 +1,77 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"html"
 	"log"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/rank"
 	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTodoNotFound = errors.New("todo not found")
+	ErrEmptySearch  = errors.New("search query has no words to search for")
+	ErrInvalidMove  = errors.New("after_id must come before before_id and neither may be the todo itself")
+)
+
+const (
+	// maxRankLength is how long rank keys may grow before the user's list
+	// is rebalanced. It is well below the 255 the column holds.
+	maxRankLength = 24
+	// rebalanceBatchSize limits how many users one rebalancing run handles.
+	rebalanceBatchSize = 50
 )
 
 type TodoService interface {
-	CreateTodo(req *models.TodoRequest) (*models.TodoResponse, error)
-	GetTodoByID(id int64) (*models.TodoResponse, error)
-	GetAllTodos() ([]models.TodoResponse, error)
-	GetTodosByUserID(userID uuid.UUID) ([]models.TodoResponse, error)
-	UpdateTodo(id int64, req *models.TodoRequest) (*models.TodoResponse, error)
-	DeleteTodo(id int64) error
-	ToggleComplete(id int64) (*models.TodoResponse, error)
+	CreateTodo(userID uuid.UUID, req *models.TodoRequest) (*models.TodoResponse, error)
+	GetTodoByID(userID uuid.UUID, id int64) (*models.TodoResponse, error)
+	ListTodos(userID uuid.UUID, params *models.TodoListParams) (*models.TodoPage, error)
+	SearchTodos(userID uuid.UUID, params *models.TodoSearchParams) ([]models.TodoSearchResult, error)
+	UpdateTodo(userID uuid.UUID, id int64, req *models.TodoRequest) (*models.TodoResponse, error)
+	DeleteTodo(userID uuid.UUID, id int64) error
+	ToggleComplete(userID uuid.UUID, id int64) (*models.TodoResponse, error)
+	MoveTodo(userID uuid.UUID, id int64, req *models.MoveTodoRequest) (*models.TodoResponse, error)
+	RebalanceRanks() (int, error)
 }
 
 type TodoServiceImpl struct {
-	repo repository.TodoRepository
+	repo         repository.TodoRepository
+	reminderRepo repository.ReminderRepository
 }
 
-func NewTodoService(repo repository.TodoRepository) TodoService {
-	return &TodoServiceImpl{repo: repo}
+func NewTodoService(repo repository.TodoRepository, reminderRepo repository.ReminderRepository) TodoService {
+	return &TodoServiceImpl{repo: repo, reminderRepo: reminderRepo}
 }
 
-func (s *TodoServiceImpl) CreateTodo(req *models.TodoRequest) (*models.TodoResponse, error) {
+func (s *TodoServiceImpl) CreateTodo(userID uuid.UUID, req *models.TodoRequest) (*models.TodoResponse, error) {
 	if req.Title == "" {
 		return nil, errors.New("title is required")
 	}
+	if err := checkDueTimeZone(req.DueTimeZone); err != nil {
+		return nil, err
+	}
 
 	todo := &models.Todo{
 		Title:       req.Title,
 		Description: req.Description,
 		Completed:   req.Completed,
-		UserID:      req.UserID,
+		Priority:    req.Priority,
+		UserID:      userID,
+	}
+	setDue(todo, req)
+
+	// New todos go to the end of the manual order.
+	var err error
+	if todo.Rank, err = s.appendKey(userID); err != nil {
+		return nil, err
 	}
 
 	if err := s.repo.Create(todo); err != nil {
@@ -48,75 +82,326 @@ func (s *TodoServiceImpl) CreateTodo(req *models.TodoRequest) (*models.TodoRespo
 	return s.todoToResponse(todo), nil
 }
 
-func (s *TodoServiceImpl) GetTodoByID(id int64) (*models.TodoResponse, error) {
-	todo, err := s.repo.GetByID(id)
+func (s *TodoServiceImpl) GetTodoByID(userID uuid.UUID, id int64) (*models.TodoResponse, error) {
+	todo, err := s.repo.GetByID(userID, id)
 	if err != nil {
-		return nil, err
+		return nil, notFoundAs(err, ErrTodoNotFound)
 	}
 	return s.todoToResponse(todo), nil
 }
 
-func (s *TodoServiceImpl) GetAllTodos() ([]models.TodoResponse, error) {
-	todos, err := s.repo.GetAll()
+// ListTodos returns one page of the user's todos, filtered and sorted as
+// params say. params.Cursor is empty for the first page or one of the
+// cursors of a previous page with the same sort order.
+func (s *TodoServiceImpl) ListTodos(userID uuid.UUID, params *models.TodoListParams) (*models.TodoPage, error) {
+	sort, canonical, err := parseTodoSort(params.Sort)
 	if err != nil {
 		return nil, err
 	}
+	query := models.TodoListQuery{
+		Filter: models.TodoFilter{
+			Completed:     params.Completed,
+			CreatedAfter:  params.CreatedAfter,
+			CreatedBefore: params.CreatedBefore,
+			UpdatedAfter:  params.UpdatedAfter,
+			UpdatedBefore: params.UpdatedBefore,
+			TitleContains: params.Title,
+			Overdue:       params.Overdue,
+			Now:           time.Now(),
+			TagsAny:       splitTagNames(params.TagsAny),
+			TagsAll:       splitTagNames(params.TagsAll),
+		},
+		Sort:  sort,
+		Limit: pageLimit(params.Limit) + 1,
+	}
+	if params.Cursor != "" {
+		query.Cursor, err = decodeTodoCursor(params.Cursor, sort, canonical)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	responses := make([]models.TodoResponse, len(todos))
+	// One extra row tells whether there is another page in that direction.
+	todos, err := s.repo.ListByUser(userID, query)
+	if err != nil {
+		return nil, err
+	}
+	backward := query.Cursor != nil && query.Cursor.Backward
+	more := len(todos) == query.Limit
+	if more && backward {
+		todos = todos[1:]
+	} else if more {
+		todos = todos[:len(todos)-1]
+	}
+
+	page := &models.TodoPage{Items: make([]models.TodoResponse, len(todos))}
 	for i, todo := range todos {
-		responses[i] = *s.todoToResponse(&todo)
+		page.Items[i] = *s.todoToResponse(&todo)
 	}
-	return responses, nil
+	if len(todos) == 0 {
+		return page, nil
+	}
+
+	// Going one way means there is a page the other way, namely the one the
+	// cursor came from.
+	hasNext, hasPrev := more, query.Cursor != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		page.NextCursor = encodeTodoCursor(&todos[len(todos)-1], sort, canonical, false)
+	}
+	if hasPrev {
+		page.PrevCursor = encodeTodoCursor(&todos[0], sort, canonical, true)
+	}
+	return page, nil
 }
 
-func (s *TodoServiceImpl) GetTodosByUserID(userID uuid.UUID) ([]models.TodoResponse, error) {
-	todos, err := s.repo.GetByUserID(userID)
+// SearchTodos returns the user's todos that best match params.Q.
+func (s *TodoServiceImpl) SearchTodos(userID uuid.UUID, params *models.TodoSearchParams) ([]models.TodoSearchResult, error) {
+	hits, err := s.repo.Search(userID, models.TodoSearchQuery{Text: params.Q, Limit: pageLimit(params.Limit)})
+	if errors.Is(err, repository.ErrEmptySearch) {
+		return nil, ErrEmptySearch
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	responses := make([]models.TodoResponse, len(todos))
-	for i, todo := range todos {
-		responses[i] = *s.todoToResponse(&todo)
+	results := make([]models.TodoSearchResult, len(hits))
+	for i, hit := range hits {
+		results[i] = models.TodoSearchResult{
+			TodoResponse: models.TodoResponse{
+				ID:          hit.ID,
+				Title:       hit.Title,
+				Description: hit.Description,
+				Completed:   hit.Completed,
+				DueAt:       localDueAt(hit.DueAt, hit.DueTimeZone),
+				DueTimeZone: hit.DueTimeZone,
+				Priority:    hit.Priority,
+				CreatedAt:   hit.CreatedAt,
+				UpdatedAt:   hit.UpdatedAt,
+				UserID:      hit.UserID,
+			},
+			Rank:               hit.Rank,
+			TitleHighlight:     highlightHTML(hit.TitleHighlight),
+			DescriptionSnippet: highlightHTML(hit.DescriptionSnippet),
+		}
+	}
+	return results, nil
+}
+
+// highlightHTML escapes a search highlight for HTML and turns the highlight
+// markers into <mark> tags. Markers that were already in the text cannot
+// leave a tag open or close one that is not.
+func highlightHTML(text string) string {
+	var b strings.Builder
+	open := false
+	for {
+		i := strings.IndexAny(text, models.HighlightStart+models.HighlightStop)
+		if i < 0 {
+			break
+		}
+		b.WriteString(html.EscapeString(text[:i]))
+		marker, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case string(marker) == models.HighlightStart && !open:
+			b.WriteString("<mark>")
+			open = true
+		case string(marker) == models.HighlightStop && open:
+			b.WriteString("</mark>")
+			open = false
+		}
+		text = text[i+size:]
+	}
+	b.WriteString(html.EscapeString(text))
+	if open {
+		b.WriteString("</mark>")
 	}
-	return responses, nil
+	return b.String()
 }
 
-func (s *TodoServiceImpl) UpdateTodo(id int64, req *models.TodoRequest) (*models.TodoResponse, error) {
-	todo, err := s.repo.GetByID(id)
+func (s *TodoServiceImpl) UpdateTodo(userID uuid.UUID, id int64, req *models.TodoRequest) (*models.TodoResponse, error) {
+	todo, err := s.repo.GetByID(userID, id)
 	if err != nil {
+		return nil, notFoundAs(err, ErrTodoNotFound)
+	}
+
+	if err := checkDueTimeZone(req.DueTimeZone); err != nil {
 		return nil, err
 	}
 
+	oldDueAt := todo.DueAt
 	todo.Title = req.Title
 	todo.Description = req.Description
 	todo.Completed = req.Completed
+	todo.Priority = req.Priority
+	setDue(todo, req)
 	todo.UpdatedAt = time.Now()
 
 	if err := s.repo.Update(todo); err != nil {
 		return nil, err
 	}
+	if !sameTime(oldDueAt, todo.DueAt) {
+		if err := s.reminderRepo.Reschedule(todo.ID, todo.DueAt, time.Now()); err != nil {
+			return nil, err
+		}
+	}
 
 	return s.todoToResponse(todo), nil
 }
 
-func (s *TodoServiceImpl) DeleteTodo(id int64) error {
-	return s.repo.Delete(id)
+func (s *TodoServiceImpl) DeleteTodo(userID uuid.UUID, id int64) error {
+	return notFoundAs(s.repo.Delete(userID, id), ErrTodoNotFound)
 }
 
-func (s *TodoServiceImpl) ToggleComplete(id int64) (*models.TodoResponse, error) {
-	if err := s.repo.ToggleComplete(id); err != nil {
-		return nil, err
+func (s *TodoServiceImpl) ToggleComplete(userID uuid.UUID, id int64) (*models.TodoResponse, error) {
+	if err := s.repo.ToggleComplete(userID, id); err != nil {
+		return nil, notFoundAs(err, ErrTodoNotFound)
 	}
 
-	todo, err := s.repo.GetByID(id)
+	todo, err := s.repo.GetByID(userID, id)
 	if err != nil {
-		return nil, err
+		return nil, notFoundAs(err, ErrTodoNotFound)
 	}
 
 	return s.todoToResponse(todo), nil
 }
 
+// MoveTodo puts the todo between the given neighbours in the manual order
+// by giving it a rank key between theirs. Only that todo is updated, unless
+// there is no room between the keys or the new key would be longer than
+// maxRankLength, in which case the list is rebalanced first.
+func (s *TodoServiceImpl) MoveTodo(userID uuid.UUID, id int64, req *models.MoveTodoRequest) (*models.TodoResponse, error) {
+	if req.AfterID == nil && req.BeforeID == nil ||
+		req.AfterID != nil && *req.AfterID == id ||
+		req.BeforeID != nil && *req.BeforeID == id {
+		return nil, ErrInvalidMove
+	}
+	if _, err := s.repo.GetByID(userID, id); err != nil {
+		return nil, notFoundAs(err, ErrTodoNotFound)
+	}
+
+	for attempt := 0; ; attempt++ {
+		key, err := s.moveKey(userID, req)
+		if err != nil && !errors.Is(err, rank.ErrInvalidRange) {
+			return nil, err
+		}
+		if err == nil && (len(key) <= maxRankLength || attempt > 0) {
+			if err := s.repo.SetRank(userID, id, key); err != nil {
+				return nil, notFoundAs(err, ErrTodoNotFound)
+			}
+			break
+		}
+		if attempt > 0 {
+			return nil, ErrInvalidMove
+		}
+		// Equal keys and todos without one leave no room in between.
+		if err := s.repo.Rebalance(userID); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetTodoByID(userID, id)
+}
+
+// appendKey returns a rank key after the user's last todo. If that key
+// would be longer than maxRankLength, the list is rebalanced first.
+func (s *TodoServiceImpl) appendKey(userID uuid.UUID) (string, error) {
+	for attempt := 0; ; attempt++ {
+		last, err := s.repo.LastRank(userID)
+		if err != nil {
+			return "", err
+		}
+		key, err := rank.Between(last, "")
+		if err != nil || len(key) <= maxRankLength || attempt > 0 {
+			return key, err
+		}
+		if err := s.repo.Rebalance(userID); err != nil {
+			return "", err
+		}
+	}
+}
+
+// moveKey returns a rank key between the moved todo's new neighbours. A
+// missing neighbour is the one next to the given one, so the todo lands
+// right after AfterID or right before BeforeID. It returns
+// rank.ErrInvalidRange if there is no room or a neighbour has no rank yet.
+func (s *TodoServiceImpl) moveKey(userID uuid.UUID, req *models.MoveTodoRequest) (string, error) {
+	var after, before string
+	if req.AfterID != nil {
+		neighbour, err := s.repo.GetByID(userID, *req.AfterID)
+		if err != nil {
+			return "", notFoundAs(err, ErrTodoNotFound)
+		}
+		if neighbour.Rank == "" {
+			return "", rank.ErrInvalidRange
+		}
+		after = neighbour.Rank
+	}
+	if req.BeforeID != nil {
+		neighbour, err := s.repo.GetByID(userID, *req.BeforeID)
+		if err != nil {
+			return "", notFoundAs(err, ErrTodoNotFound)
+		}
+		if neighbour.Rank == "" {
+			return "", rank.ErrInvalidRange
+		}
+		before = neighbour.Rank
+	}
+
+	var err error
+	switch {
+	case req.BeforeID == nil:
+		before, err = s.repo.RankAfter(userID, after)
+	case req.AfterID == nil:
+		after, err = s.repo.RankBefore(userID, before)
+	}
+	if err != nil {
+		return "", err
+	}
+	return rank.Between(after, before)
+}
+
+// RebalanceRanks rebalances the lists whose rank keys have grown too long
+// and gives todos from before manual ordering a rank. It returns how many
+// users' lists it rebalanced.
+func (s *TodoServiceImpl) RebalanceRanks() (int, error) {
+	userIDs, err := s.repo.ListUsersToRebalance(maxRankLength, rebalanceBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i, userID := range userIDs {
+		if err := s.repo.Rebalance(userID); err != nil {
+			return i, err
+		}
+	}
+	return len(userIDs), nil
+}
+
+// StartRankRebalancer runs RebalanceRanks right away and then every
+// interval until ctx is done.
+func StartRankRebalancer(ctx context.Context, todos TodoService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for {
+				rebalanced, err := todos.RebalanceRanks()
+				if err != nil {
+					log.Printf("Error rebalancing todo ranks: %v", err)
+				}
+				if err != nil || rebalanced < rebalanceBatchSize {
+					break
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
 // Helper method to convert Todo to TodoResponse
 func (s *TodoServiceImpl) todoToResponse(todo *models.Todo) *models.TodoResponse {
 	return &models.TodoResponse{
@@ -124,8 +409,60 @@ func (s *TodoServiceImpl) todoToResponse(todo *models.Todo) *models.TodoResponse
 		Title:       todo.Title,
 		Description: todo.Description,
 		Completed:   todo.Completed,
+		DueAt:       localDueAt(todo.DueAt, todo.DueTimeZone),
+		DueTimeZone: todo.DueTimeZone,
+		Priority:    todo.Priority,
+		Tags:        todo.Tags,
 		CreatedAt:   todo.CreatedAt,
 		UpdatedAt:   todo.UpdatedAt,
 		UserID:      todo.UserID,
 	}
 }
+
+// checkDueTimeZone returns a *ValidationError unless zone is empty or an
+// IANA time zone name.
+func checkDueTimeZone(zone string) error {
+	if zone == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(zone); err != nil {
+		return &ValidationError{Fields: []FieldError{{Field: "due_time_zone", Message: "must be an IANA time zone such as Europe/Berlin"}}}
+	}
+	return nil
+}
+
+// setDue stores the due date in UTC and remembers the zone it belongs to.
+func setDue(todo *models.Todo, req *models.TodoRequest) {
+	todo.DueAt, todo.DueTimeZone = nil, ""
+	if req.DueAt != nil {
+		dueAt := req.DueAt.UTC()
+		todo.DueAt, todo.DueTimeZone = &dueAt, req.DueTimeZone
+	}
+}
+
+// localDueAt shows a due date in the todo's time zone.
+func localDueAt(dueAt *time.Time, zone string) *time.Time {
+	if dueAt == nil {
+		return nil
+	}
+	local := *dueAt
+	if location, err := time.LoadLocation(zone); err == nil {
+		local = local.In(location)
+	}
+	return &local
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// notFoundAs replaces gorm's record-not-found error with a domain error.
+func notFoundAs(err, target error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return target
+	}
+	return err
+}
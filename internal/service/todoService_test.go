@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/qsheker/ToDo-app/internal/models"
+)
+
+func TestHighlightHTML(t *testing.T) {
+	start, stop := models.HighlightStart, models.HighlightStop
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "buy milk", "buy milk"},
+		{"match", "buy " + start + "milk" + stop, "buy <mark>milk</mark>"},
+		{"escapes text", `<img src=x onerror="alert(1)"> ` + start + "milk" + stop, `&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>milk</mark>`},
+		{"escapes inside match", start + "<b>" + stop, "<mark>&lt;b&gt;</mark>"},
+		{"stray stop", stop + "milk", "milk"},
+		{"unclosed start", start + "milk", "<mark>milk</mark>"},
+		{"nested start", start + start + "milk" + stop + stop, "<mark>milk</mark>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightHTML(tt.in); got != tt.want {
+				t.Fatalf("highlightHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
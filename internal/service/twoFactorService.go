@@ -0,0 +1,377 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer             = "ToDo App"
+	totpPeriod             = 30
+	totpSkew               = 1
+	recoveryCodeCount      = 10
+	twoFactorChallengeTTL  = 5 * time.Minute
+	twoFactorAudience      = "2fa-challenge"
+	twoFactorChallengeType = "2fa-challenge+jwt"
+
+	// twoFactorChallengeAttempts is how many wrong codes one challenge takes
+	// before it is revoked and the password has to be entered again.
+	twoFactorChallengeAttempts = 5
+	// twoFactorCodeLockout is how long Confirm and Disable refuse codes once
+	// twoFactorChallengeAttempts wrong ones were entered within it.
+	twoFactorCodeLockout = 15 * time.Minute
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolling   = errors.New("start enrollment before confirming it")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrInvalidChallenge        = errors.New("invalid or expired two-factor challenge")
+	ErrTooManyTwoFactorCodes   = errors.New("too many invalid two-factor codes, try again later")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorService interface {
+	Enroll(userID uuid.UUID) (*models.TwoFactorEnrollResponse, error)
+	Confirm(userID uuid.UUID, code string) ([]string, error)
+	Disable(userID uuid.UUID, code string) error
+	Challenge(user *models.User) (string, error)
+	ChallengedUser(challengeToken string) (*models.User, error)
+	CompleteChallenge(challengeToken, code string) (*models.User, error)
+}
+
+type TwoFactorServiceImpl struct {
+	keys         *KeySet
+	userRepo     repository.UserRepository
+	recoveryRepo repository.RecoveryCodeRepository
+	attempts     repository.AttemptStore
+	revocations  repository.RevocationStore
+}
+
+func NewTwoFactorService(keys *KeySet, userRepo repository.UserRepository, recoveryRepo repository.RecoveryCodeRepository, attempts repository.AttemptStore, revocations repository.RevocationStore) TwoFactorService {
+	return &TwoFactorServiceImpl{keys: keys, userRepo: userRepo, recoveryRepo: recoveryRepo, attempts: attempts, revocations: revocations}
+}
+
+// Enroll generates a new TOTP secret. It only takes effect after Confirm.
+func (s *TwoFactorServiceImpl) Enroll(userID uuid.UUID) (*models.TwoFactorEnrollResponse, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Username,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, err
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		return nil, err
+	}
+
+	user.TOTPPendingSecret = key.Secret()
+	user.UpdatedAt = time.Now()
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorEnrollResponse{
+		Secret:     key.Secret(),
+		OTPAuthURI: key.URL(),
+		QRCodePNG:  base64.StdEncoding.EncodeToString(qr.Bytes()),
+	}, nil
+}
+
+// Confirm enables 2FA once the user proves their authenticator works and
+// returns freshly generated recovery codes.
+func (s *TwoFactorServiceImpl) Confirm(userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, ErrTwoFactorNotEnrolling
+	}
+	if err := s.checkCodeLockout(user.ID); err != nil {
+		return nil, err
+	}
+
+	step, ok := matchTOTP(user.TOTPPendingSecret, code, time.Now())
+	if !ok {
+		if err := s.recordCodeFailure(user.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTwoFactorCode
+	}
+	if err := s.attempts.Reset(codeAttemptKey(user.ID)); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.replaceRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPSecret = user.TOTPPendingSecret
+	user.TOTPPendingSecret = ""
+	user.TOTPLastStep = step
+	user.TwoFactorEnabled = true
+	user.UpdatedAt = time.Now()
+	if err := s.userRepo.Update(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *TwoFactorServiceImpl) Disable(userID uuid.UUID, code string) error {
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := s.checkCodeLockout(user.ID); err != nil {
+		return err
+	}
+	if err := s.verifyCode(user, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			if err := s.recordCodeFailure(user.ID); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	if err := s.attempts.Reset(codeAttemptKey(user.ID)); err != nil {
+		return err
+	}
+
+	if err := s.recoveryRepo.Replace(user.ID, nil); err != nil {
+		return err
+	}
+	user.TwoFactorEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPPendingSecret = ""
+	user.UpdatedAt = time.Now()
+	return s.userRepo.Update(user)
+}
+
+// Challenge returns a short-lived token that proves the password step passed.
+func (s *TwoFactorServiceImpl) Challenge(user *models.User) (string, error) {
+	now := time.Now()
+	return s.keys.signInternal(twoFactorChallengeType, jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   user.ID.String(),
+		Audience:  jwt.ClaimStrings{twoFactorAudience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(twoFactorChallengeTTL)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	})
+}
+
+// ChallengedUser returns the user a challenge token was issued to without
+// checking a code, so that wrong codes can be counted against the account.
+func (s *TwoFactorServiceImpl) ChallengedUser(challengeToken string) (*models.User, error) {
+	_, user, err := s.parseChallenge(challengeToken)
+	return user, err
+}
+
+// CompleteChallenge checks the second factor for a challenge token and
+// returns the user to issue real tokens for. A challenge works once and is
+// revoked after twoFactorChallengeAttempts wrong codes.
+func (s *TwoFactorServiceImpl) CompleteChallenge(challengeToken, code string) (*models.User, error) {
+	claims, user, err := s.parseChallenge(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verifyCode(user, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			if err := s.recordChallengeFailure(claims); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	if err := s.revocations.RevokeToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return nil, err
+	}
+	if err := s.attempts.Reset(challengeAttemptKey(claims.ID)); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *TwoFactorServiceImpl) parseChallenge(challengeToken string) (*jwt.RegisteredClaims, *models.User, error) {
+	var claims jwt.RegisteredClaims
+	err := s.keys.parseInternal(challengeToken, twoFactorChallengeType, &claims,
+		jwt.WithAudience(twoFactorAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil || claims.ID == "" || claims.IssuedAt == nil {
+		return nil, nil, ErrInvalidChallenge
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, nil, ErrInvalidChallenge
+	}
+
+	// Signing out everywhere also drops challenges that are still pending.
+	revoked, err := s.revocations.IsRevoked(claims.ID, uuid.Nil, userID, claims.IssuedAt.Time)
+	if err != nil {
+		return nil, nil, err
+	}
+	if revoked {
+		return nil, nil, ErrInvalidChallenge
+	}
+
+	user, err := s.userRepo.GetByID(userID)
+	if err != nil {
+		return nil, nil, notFoundAs(err, ErrInvalidChallenge)
+	}
+	if !user.TwoFactorEnabled {
+		return nil, nil, ErrInvalidChallenge
+	}
+	return &claims, user, nil
+}
+
+// recordChallengeFailure counts a wrong code against the challenge and
+// revokes it once it has used up its attempts.
+func (s *TwoFactorServiceImpl) recordChallengeFailure(claims *jwt.RegisteredClaims) error {
+	failures, err := s.attempts.RecordFailure(challengeAttemptKey(claims.ID), time.Now(), twoFactorChallengeTTL)
+	if err != nil {
+		return err
+	}
+	if failures < twoFactorChallengeAttempts {
+		return nil
+	}
+	return s.revocations.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+}
+
+func challengeAttemptKey(jti string) string {
+	return "2fa:" + jti
+}
+
+// checkCodeLockout fails while the user is locked out of Confirm and Disable.
+func (s *TwoFactorServiceImpl) checkCodeLockout(userID uuid.UUID) error {
+	lockedUntil, err := s.attempts.LockedUntil(codeAttemptKey(userID))
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(lockedUntil) {
+		return ErrTooManyTwoFactorCodes
+	}
+	return nil
+}
+
+// recordCodeFailure counts a wrong code given to Confirm or Disable and
+// locks both for the user once they have used up their attempts.
+func (s *TwoFactorServiceImpl) recordCodeFailure(userID uuid.UUID) error {
+	now := time.Now()
+	failures, err := s.attempts.RecordFailure(codeAttemptKey(userID), now, twoFactorCodeLockout)
+	if err != nil {
+		return err
+	}
+	if failures < twoFactorChallengeAttempts {
+		return nil
+	}
+	return s.attempts.Lock(codeAttemptKey(userID), now.Add(twoFactorCodeLockout))
+}
+
+func codeAttemptKey(userID uuid.UUID) string {
+	return "2fa-user:" + userID.String()
+}
+
+// verifyCode accepts a current TOTP code or an unused recovery code. A TOTP
+// code is accepted at most once, so an observed code cannot be replayed.
+func (s *TwoFactorServiceImpl) verifyCode(user *models.User, code string) error {
+	if step, ok := matchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		if err := s.userRepo.ClaimTOTPStep(user.ID, step); err != nil {
+			return notFoundAs(err, ErrInvalidTwoFactorCode)
+		}
+		return nil
+	}
+
+	err := s.recoveryRepo.Consume(user.ID, hashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidTwoFactorCode
+	}
+	return err
+}
+
+func (s *TwoFactorServiceImpl) replaceRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	entities := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+		codes[i] = raw[:4] + "-" + raw[4:]
+		entities[i] = models.RecoveryCode{
+			ID:       uuid.New(),
+			UserID:   userID,
+			CodeHash: hashToken(raw),
+		}
+	}
+	if err := s.recoveryRepo.Replace(userID, entities); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// matchTOTP checks code against the steps around t and returns the matching step.
+func matchTOTP(secret, code string, t time.Time) (int64, bool) {
+	if secret == "" {
+		return 0, false
+	}
+	code = strings.TrimSpace(code)
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	current := t.Unix() / totpPeriod
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		step := current + offset
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), opts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
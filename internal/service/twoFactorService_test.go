@@ -0,0 +1,74 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+type fakeRecoveryRepo struct {
+	repository.RecoveryCodeRepository
+}
+
+func (fakeRecoveryRepo) Replace(userID uuid.UUID, codes []models.RecoveryCode) error { return nil }
+
+func TestConfirmLocksOutAfterWrongCodes(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "alice", Period: totpPeriod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Username: "alice", TOTPPendingSecret: key.Secret()}
+	twoFactorService := NewTwoFactorService(newTestKeySet(t), newFakeUserRepo(user), fakeRecoveryRepo{}, repository.NewMemoryAttemptStore(), repository.NewMemoryRevocationStore())
+
+	for i := 0; i < twoFactorChallengeAttempts; i++ {
+		if _, err := twoFactorService.Confirm(user.ID, "000000x"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("Confirm() error = %v, want %v", err, ErrInvalidTwoFactorCode)
+		}
+	}
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := twoFactorService.Confirm(user.ID, code); !errors.Is(err, ErrTooManyTwoFactorCodes) {
+		t.Fatalf("Confirm() with the right code error = %v, want %v", err, ErrTooManyTwoFactorCodes)
+	}
+	if user.TwoFactorEnabled {
+		t.Fatal("2FA was enabled during the lockout")
+	}
+}
+
+func TestConfirmResetsFailuresOnSuccess(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "alice", Period: totpPeriod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Username: "alice", TOTPPendingSecret: key.Secret()}
+	attempts := repository.NewMemoryAttemptStore()
+	twoFactorService := NewTwoFactorService(newTestKeySet(t), newFakeUserRepo(user), fakeRecoveryRepo{}, attempts, repository.NewMemoryRevocationStore())
+
+	for i := 0; i < twoFactorChallengeAttempts-1; i++ {
+		if _, err := twoFactorService.Confirm(user.ID, "000000x"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Fatalf("Confirm() error = %v, want %v", err, ErrInvalidTwoFactorCode)
+		}
+	}
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := twoFactorService.Confirm(user.ID, code); err != nil {
+		t.Fatal(err)
+	}
+	failures, err := attempts.RecordFailure(codeAttemptKey(user.ID), time.Now(), twoFactorCodeLockout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 1 {
+		t.Fatalf("failures after success = %d, want the counter reset", failures-1)
+	}
+}
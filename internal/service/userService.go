@@ -1,46 +1,130 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/qsheker/ToDo-app/internal/models"
 	"github.com/qsheker/ToDo-app/internal/repository"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
 )
 
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 64
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUsernameTaken      = errors.New("username is already taken")
+	ErrUsernameReserved   = errors.New("username was recently used by another account")
+	ErrUsernameMoved      = errors.New("username has changed")
+)
+
+// UsernameMovedError is returned when a username lookup matches a name the
+// user has since changed. Username is the current one.
+type UsernameMovedError struct {
+	Username string
+}
+
+func (e *UsernameMovedError) Error() string {
+	return ErrUsernameMoved.Error() + " to " + e.Username
+}
+
+func (e *UsernameMovedError) Is(target error) bool {
+	return target == ErrUsernameMoved
+}
+
 type UserService interface {
-	Create(user *models.CreateUserRequest) error
+	Create(user *models.CreateUserRequest) (*models.User, error)
 	GetByID(id uuid.UUID) (*models.UserResponse, error)
 	GetByUsername(username string) (*models.UserResponse, error)
-	Update(user *models.UpdateUserRequest) error
-	Delete(id uuid.UUID) error
+	Update(actorID uuid.UUID, user *models.UpdateUserRequest) error
+	ChangeUsername(id uuid.UUID, username string) error
+	Authenticate(username, password string) (*models.User, error)
+	CheckPassword(id uuid.UUID, password string) error
+	SetPassword(id uuid.UUID, password string) error
+	RevokeCredentials(id uuid.UUID) error
+	UpdateRole(id uuid.UUID, role string) error
+	BootstrapAdmin(admin *models.CreateUserRequest) error
 	hashPassword(password string) (string, error)
 }
 
 type UserServiceImpl struct {
-	repo repository.UserRepository
+	repo        repository.UserRepository
+	historyRepo repository.PasswordHistoryRepository
+	patRepo     repository.PersonalAccessTokenRepository
+	policy      *PasswordPolicy
+	hasher      PasswordHasher
+	jwtService  JwtService
+
+	// usernameReservation is how long an old username stays reserved for
+	// its previous owner after a rename.
+	usernameReservation time.Duration
+
+	// dummyHash is verified against when the username is unknown so that
+	// failed lookups cost about as much as a wrong password.
+	dummyHash string
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
-	return &UserServiceImpl{repo: repo}
+func NewUserService(repo repository.UserRepository, historyRepo repository.PasswordHistoryRepository, patRepo repository.PersonalAccessTokenRepository, policy *PasswordPolicy, hasher PasswordHasher, jwtService JwtService, usernameReservation time.Duration) UserService {
+	dummyHash, err := hasher.Hash("dummy-password")
+	if err != nil {
+		log.Fatal("Error hashing dummy password: ", err)
+	}
+	return &UserServiceImpl{
+		repo:                repo,
+		historyRepo:         historyRepo,
+		patRepo:             patRepo,
+		policy:              policy,
+		hasher:              hasher,
+		jwtService:          jwtService,
+		usernameReservation: usernameReservation,
+		dummyHash:           dummyHash,
+	}
 }
-func (s *UserServiceImpl) Create(user *models.CreateUserRequest) error {
+func (s *UserServiceImpl) Create(user *models.CreateUserRequest) (*models.User, error) {
+	username, err := checkUsername(user.Username)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.usernameAvailable(username, uuid.Nil); err != nil {
+		return nil, err
+	}
+	if err := s.policy.Check(user.Password, username); err != nil {
+		return nil, err
+	}
 	hashedPass, err := s.hashPassword(user.Password)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	entity := &models.User{
 		ID:       uuid.New(),
 		Name:     user.Name,
-		Username: user.Username,
+		Username: username,
+		Email:    user.Email,
 		Password: hashedPass,
+		Role:     models.RoleMember,
 	}
 	if err := s.repo.Create(entity); err != nil {
-		return err
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
 	}
-	return nil
+	if err := s.recordPassword(entity); err != nil {
+		return nil, err
+	}
+	return entity, nil
 
 }
 func (s *UserServiceImpl) GetByID(id uuid.UUID) (*models.UserResponse, error) {
@@ -53,11 +137,15 @@ func (s *UserServiceImpl) GetByID(id uuid.UUID) (*models.UserResponse, error) {
 		ID:       user.ID,
 		Name:     user.Name,
 		Username: user.Username,
+		Role:     user.Role,
 	}
 	return userResponse, nil
 }
 func (s *UserServiceImpl) GetByUsername(username string) (*models.UserResponse, error) {
 	user, err := s.repo.GetByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, s.movedUsername(username)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -65,31 +153,389 @@ func (s *UserServiceImpl) GetByUsername(username string) (*models.UserResponse,
 		ID:       user.ID,
 		Name:     user.Name,
 		Username: user.Username,
+		Role:     user.Role,
 	}
 	return userResponse, nil
 }
-func (s *UserServiceImpl) Update(user *models.UpdateUserRequest) error {
-	hashed, err := s.hashPassword(user.Password)
+
+// Update changes the account on behalf of actorID. Changing your own
+// password or email takes the current password, and either change signs the
+// account out everywhere, since it may mean someone else had access.
+func (s *UserServiceImpl) Update(actorID uuid.UUID, user *models.UpdateUserRequest) error {
+	entity, err := s.repo.GetByID(user.ID)
 	if err != nil {
 		return err
 	}
-	entity := &models.User{
-		ID:        user.ID,
-		Name:      user.Name,
-		Username:  user.Username,
-		Password:  hashed,
-		UpdatedAt: time.Now(),
+	emailChanged := user.Email != "" && user.Email != entity.Email
+	if (emailChanged || user.Password != "") && actorID == user.ID {
+		if err := s.checkCurrentPassword(entity, user.CurrentPassword); err != nil {
+			return err
+		}
+	}
+	if user.Password != "" {
+		if err := s.checkPassword(entity, user.Password); err != nil {
+			return err
+		}
 	}
+
+	if user.Username != "" {
+		if err := s.ChangeUsername(user.ID, user.Username); err != nil {
+			return err
+		}
+		if entity, err = s.repo.GetByID(user.ID); err != nil {
+			return err
+		}
+	}
+	if user.Name != "" {
+		entity.Name = user.Name
+	}
+	if emailChanged {
+		entity.Email = user.Email
+		entity.EmailVerified = false
+	}
+	if user.Password != "" {
+		hashed, err := s.hashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		entity.Password = hashed
+	}
+	entity.UpdatedAt = time.Now()
+
 	if err := s.repo.Update(entity); err != nil {
 		return err
 	}
+	if user.Password != "" {
+		if err := s.recordPassword(entity); err != nil {
+			return err
+		}
+	}
+	if emailChanged || user.Password != "" {
+		return s.RevokeCredentials(user.ID)
+	}
 	return nil
+}
+
+// checkCurrentPassword returns a *ValidationError unless password is the
+// user's current one. Accounts created through SSO have none to check.
+func (s *UserServiceImpl) checkCurrentPassword(user *models.User, password string) error {
+	if user.Password == "" {
+		return nil
+	}
+	if password == "" {
+		return &ValidationError{Fields: []FieldError{{
+			Field:   "current_password",
+			Message: "is required to change your password or email",
+		}}}
+	}
+	match, _, err := s.hasher.Verify(user.Password, password)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return &ValidationError{Fields: []FieldError{{
+			Field:   "current_password",
+			Message: "is incorrect",
+		}}}
+	}
+	return nil
+}
+
+// ChangeUsername renames the user. The old name stays reserved for them for
+// usernameReservation and lookups of it redirect to the new one.
+func (s *UserServiceImpl) ChangeUsername(id uuid.UUID, username string) error {
+	username, err := checkUsername(username)
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return notFoundAs(err, ErrUserNotFound)
+	}
+	if user.Username == username {
+		return nil
+	}
+
+	// A change of case or Unicode form keeps the key and needs no alias.
+	var alias *models.UsernameAlias
+	if models.NormalizeUsername(user.Username) != models.NormalizeUsername(username) {
+		if err := s.usernameAvailable(username, id); err != nil {
+			return err
+		}
+		alias = &models.UsernameAlias{
+			Key:           models.NormalizeUsername(user.Username),
+			Username:      user.Username,
+			UserID:        id,
+			ReservedUntil: time.Now().Add(s.usernameReservation),
+			CreatedAt:     time.Now(),
+		}
+	}
+
+	err = s.repo.ChangeUsername(id, username, alias)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrUsernameTaken
+	}
+	return notFoundAs(err, ErrUserNotFound)
+}
+
+// usernameAvailable returns ErrUsernameTaken or ErrUsernameReserved unless
+// username is free for the user with the given ID, uuid.Nil for a new user.
+func (s *UserServiceImpl) usernameAvailable(username string, id uuid.UUID) error {
+	existing, err := s.repo.GetByUsername(username)
+	if err == nil && existing.ID != id {
+		return ErrUsernameTaken
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	alias, err := s.repo.GetUsernameAlias(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if alias.UserID != id && alias.ReservedUntil.After(time.Now()) {
+		return ErrUsernameReserved
+	}
+	return nil
+}
+
+// movedUsername returns a *UsernameMovedError if username used to belong to
+// a user who still exists, and ErrUserNotFound otherwise.
+func (s *UserServiceImpl) movedUsername(username string) error {
+	alias, err := s.repo.GetUsernameAlias(username)
+	if err != nil {
+		return notFoundAs(err, ErrUserNotFound)
+	}
+	user, err := s.repo.GetByID(alias.UserID)
+	if err != nil {
+		return notFoundAs(err, ErrUserNotFound)
+	}
+	return &UsernameMovedError{Username: user.Username}
+}
+
+// checkUsername returns the username in NFKC form, or a *ValidationError
+// if it is too short or long or has characters other than letters, digits,
+// '.', '_' and '-'.
+func checkUsername(username string) (string, error) {
+	username = norm.NFKC.String(strings.TrimSpace(username))
+
+	verr := &ValidationError{}
+	if length := utf8.RuneCountInString(username); length < minUsernameLength || length > maxUsernameLength {
+		verr.add("username", fmt.Sprintf("must be between %d and %d characters long", minUsernameLength, maxUsernameLength))
+	}
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r) && r != '.' && r != '_' && r != '-' {
+			verr.add("username", "may only contain letters, digits, '.', '_' and '-'")
+			break
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return "", verr
+	}
+	return username, nil
+}
 
+func (s *UserServiceImpl) Authenticate(username, password string) (*models.User, error) {
+	user, err := s.repo.GetByUsername(username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			_, _, _ = s.hasher.Verify(s.dummyHash, password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if user.Password == "" {
+		// Accounts created through SSO have no password until one is set.
+		_, _, _ = s.hasher.Verify(s.dummyHash, password)
+		return nil, ErrInvalidCredentials
+	}
+
+	match, needsRehash, err := s.hasher.Verify(user.Password, password)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		go s.rehash(user.ID, user.Password, password)
+	}
+	return user, nil
 }
-func (s *UserServiceImpl) Delete(id uuid.UUID) error {
-	return s.repo.Delete(id)
+
+// rehash upgrades a hash made with an older algorithm or weaker parameters.
+// It only replaces oldHash, so a password changed in the meantime is kept.
+func (s *UserServiceImpl) rehash(id uuid.UUID, oldHash, password string) {
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		log.Println("Error rehashing password: ", err)
+		return
+	}
+	if err := s.repo.ReplacePasswordHash(id, oldHash, hash); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("Error storing rehashed password: ", err)
+	}
 }
+
+// CheckPassword validates a new password for the user without setting it.
+func (s *UserServiceImpl) CheckPassword(id uuid.UUID, password string) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	return s.checkPassword(user, password)
+}
+
+func (s *UserServiceImpl) SetPassword(id uuid.UUID, password string) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkPassword(user, password); err != nil {
+		return err
+	}
+	hashed, err := s.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashed
+	user.UpdatedAt = time.Now()
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	return s.recordPassword(user)
+}
+
+// UpdateRole changes the user's role and revokes their credentials, so no
+// access token keeps claiming the old role and no personal access token
+// keeps acting with it.
+func (s *UserServiceImpl) UpdateRole(id uuid.UUID, role string) error {
+	if !models.IsValidRole(role) {
+		return errors.New("unknown role " + role)
+	}
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if user.Role == role {
+		return nil
+	}
+	user.Role = role
+	user.UpdatedAt = time.Now()
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	return s.RevokeCredentials(id)
+}
+
+// RevokeCredentials signs the user out everywhere and revokes their personal
+// access tokens, which outlive sessions.
+func (s *UserServiceImpl) RevokeCredentials(id uuid.UUID) error {
+	if err := s.jwtService.LogoutAll(id); err != nil {
+		return err
+	}
+	return s.patRepo.RevokeByUser(id)
+}
+
+// BootstrapAdmin makes sure at least one admin exists. It does nothing once
+// any admin is present; otherwise it promotes the named user or creates it,
+// applying the same username and password rules as sign-up.
+func (s *UserServiceImpl) BootstrapAdmin(admin *models.CreateUserRequest) error {
+	count, err := s.repo.CountByRole(models.RoleAdmin)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	existing, err := s.repo.GetByUsername(admin.Username)
+	if err == nil {
+		log.Printf("Promoting existing user %s to admin", existing.Username)
+		return s.UpdateRole(existing.ID, models.RoleAdmin)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if admin.Password == "" {
+		return errors.New("bootstrap admin password is required")
+	}
+	username, err := checkUsername(admin.Username)
+	if err != nil {
+		return err
+	}
+	if err := s.usernameAvailable(username, uuid.Nil); err != nil {
+		return err
+	}
+	if err := s.policy.Check(admin.Password, username); err != nil {
+		return err
+	}
+
+	hashedPass, err := s.hashPassword(admin.Password)
+	if err != nil {
+		return err
+	}
+	// The operator created this account, so there is no mailbox to confirm.
+	log.Printf("Creating bootstrap admin %s", username)
+	entity := &models.User{
+		ID:            uuid.New(),
+		Name:          admin.Name,
+		Username:      username,
+		Password:      hashedPass,
+		Role:          models.RoleAdmin,
+		EmailVerified: true,
+	}
+	if err := s.repo.Create(entity); err != nil {
+		return err
+	}
+	return s.recordPassword(entity)
+}
+
+// checkPassword applies the password policy, including that none of the
+// user's last HistorySize passwords may be reused.
+func (s *UserServiceImpl) checkPassword(user *models.User, password string) error {
+	if err := s.policy.Check(password, user.Username); err != nil {
+		return err
+	}
+
+	limit := s.policy.HistorySize()
+	if limit <= 0 {
+		return nil
+	}
+	hashes := []string{user.Password}
+	history, err := s.historyRepo.Recent(user.ID, limit)
+	if err != nil {
+		return err
+	}
+	for _, entry := range history {
+		hashes = append(hashes, entry.Hash)
+	}
+	for _, hash := range hashes {
+		if hash == "" {
+			continue
+		}
+		if match, _, err := s.hasher.Verify(hash, password); err == nil && match {
+			return &ValidationError{Fields: []FieldError{{
+				Field:   "password",
+				Message: fmt.Sprintf("must differ from your last %d passwords", limit),
+			}}}
+		}
+	}
+	return nil
+}
+
+func (s *UserServiceImpl) recordPassword(user *models.User) error {
+	limit := s.policy.HistorySize()
+	if limit <= 0 {
+		return nil
+	}
+	return s.historyRepo.Add(&models.PasswordHistory{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Hash:      user.Password,
+		CreatedAt: time.Now(),
+	}, limit)
+}
+
 func (s *UserServiceImpl) hashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(hash), err
+	return s.hasher.Hash(password)
 }
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qsheker/ToDo-app/internal/models"
+	"github.com/qsheker/ToDo-app/internal/repository"
+)
+
+func (repo *fakeUserRepo) Update(user *models.User) error {
+	repo.users[user.ID] = user
+	return nil
+}
+
+type fakePATRepo struct {
+	repository.PersonalAccessTokenRepository
+	revokedUsers []uuid.UUID
+}
+
+func (repo *fakePATRepo) RevokeByUser(userID uuid.UUID) error {
+	repo.revokedUsers = append(repo.revokedUsers, userID)
+	return nil
+}
+
+func newTestUserService(t *testing.T, pats *fakePATRepo, users ...*models.User) (UserService, *fakeUserRepo) {
+	t.Helper()
+	userRepo := newFakeUserRepo(users...)
+	jwtService := newTestJwtService(t, users...)
+	hasher := NewPasswordHasher(Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1})
+	return NewUserService(userRepo, nil, pats, NewPasswordPolicy(PasswordPolicyConfig{}), hasher, jwtService, time.Hour), userRepo
+}
+
+func TestUpdateRoleRevokesPersonalTokens(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: models.RoleAdmin}
+	pats := &fakePATRepo{}
+	userService, users := newTestUserService(t, pats, user)
+
+	if err := userService.UpdateRole(user.ID, models.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if users.users[user.ID].Role != models.RoleMember {
+		t.Fatalf("role = %q, want %q", users.users[user.ID].Role, models.RoleMember)
+	}
+	if len(pats.revokedUsers) != 1 || pats.revokedUsers[0] != user.ID {
+		t.Fatalf("revoked personal tokens of %v, want %v", pats.revokedUsers, user.ID)
+	}
+
+	// Setting the same role again changes nothing.
+	if err := userService.UpdateRole(user.ID, models.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if len(pats.revokedUsers) != 1 {
+		t.Fatalf("revoked personal tokens %d times, want once", len(pats.revokedUsers))
+	}
+}